@@ -5,16 +5,21 @@ import (
 	"fmt"
 	"gopkg.in/reform.v1"
 	"math/big"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/AlekSi/pointer"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/pkg/errors"
 
+	"github.com/dzeckelev/geth-wrapper/config"
 	"github.com/dzeckelev/geth-wrapper/data"
 	"github.com/dzeckelev/geth-wrapper/eth"
 	"github.com/dzeckelev/geth-wrapper/gen"
+	"github.com/dzeckelev/geth-wrapper/proc"
 )
 
 // Handler is an API RPC handler.
@@ -24,7 +29,14 @@ type Handler struct {
 	genUUIDFunc func() string
 	networkID   *big.Int
 
-	// Mutex is needed to synchronize requests.
+	depositWallet *eth.DepositWallet
+	nonces        *eth.NonceManager
+	scheduler     *proc.Scheduler
+	finality      *proc.Finality
+	archiveNode   bool
+
+	// Mutex is needed to synchronize requests, which mark transactions.
+	// Sends are synchronized per sender by the nonce manager.
 	mtx sync.Mutex
 }
 
@@ -37,8 +49,90 @@ type GetLastResult struct {
 	// String type because it can go beyond uint64.
 	Amount        string
 	Confirmations uint64
+	// Final is true if the transaction has enough confirmations
+	// by Proc.Confirmations and Proc.ConfirmationTiers.
+	Final bool
+	// TracePath is set for ETH transfers made by contract internal calls.
+	TracePath *string
+	// Gas and fees are not set for internal calls.
+	GasLimit *uint64
+	GasUsed  *uint64
+	// Fees are in Wei (1 ETH = 10^18 Wei).
+	EffectiveGasPrice *string
+	Fee               *string
+	// BurnedFee and TipFee are parts of Fee, they are not set
+	// for blocks before EIP-1559.
+	BurnedFee *string
+	TipFee    *string
+	// TxType is 0 for legacy, 1 for access list
+	// and 2 for dynamic fee transactions.
+	TxType *uint64
+}
+
+// GetLastTokensResult is result of GetLastTokens method.
+type GetLastTokensResult struct {
+	Hash     string
+	LogIndex uint64
+	Date     string
+	Token    string
+	Address  string
+	// In the smallest token units, without applying token decimals.
+	// String type because it can go beyond uint64.
+	Amount        string
+	Confirmations uint64
+	// Final is true if the transfer has enough confirmations
+	// by Proc.Confirmations and Proc.ConfirmationTiers.
+	Final bool
+}
+
+// NewDepositAddressResult is result of NewDepositAddress method.
+type NewDepositAddressResult struct {
+	Address string
+	// Path is a BIP-32 derivation path of the address.
+	Path string
+}
+
+// GetWithdrawalResult is result of GetWithdrawal method.
+type GetWithdrawalResult struct {
+	Hash    string
+	Account string
+	// Kind is withdrawal or sweep.
+	Kind string
+	// In Wei (1 ETH = 10^18 Wei)
+	Amount *string
+	// Status is pending, successful, failed, replaced or dropped.
+	Status string
+	// MinedHash is a hash of the mined transaction with the same nonce.
+	// It differs from Hash if the withdrawal has been replaced.
+	MinedHash *string
+	// ReplacedBy is a hash of the replacement transaction.
+	ReplacedBy *string
+	Block      *uint64
+	GasUsed    *uint64
+	// In Wei (1 ETH = 10^18 Wei)
+	EffectiveGasPrice *string
+	// Fee, BurnedFee and TipFee are in Wei, they are set once
+	// the mined transaction is collected.
+	Fee           *string
+	BurnedFee     *string
+	TipFee        *string
+	Confirmations uint64
+	SentAt        *string
+	MinedAt       *string
 }
 
+// BalanceHistoryPoint is a balance of an account at a block.
+type BalanceHistoryPoint struct {
+	Date  string
+	Block uint64
+	// In Wei (1 ETH = 10^18 Wei)
+	Balance string
+}
+
+// nextDerivationIndex is a setting with the index
+// of the next deposit address.
+const nextDerivationIndex = "nextDerivationIndex"
+
 // NewHandler creates a new handler.
 func NewHandler(networkID *big.Int, database *reform.DB,
 	ethClient eth.Client, genUUIDFunc func() string) *Handler {
@@ -47,9 +141,78 @@ func NewHandler(networkID *big.Int, database *reform.DB,
 		database:    database,
 		genUUIDFunc: genUUIDFunc,
 		ethClient:   ethClient,
+		finality:    proc.NewFinality(config.NewConfig().Proc),
 	}
 }
 
+// SetFinality sets the rules, by which transactions are final.
+func (h *Handler) SetFinality(finality *proc.Finality) {
+	h.finality = finality
+}
+
+// SetArchiveNode enables reading historical balances from the node,
+// which keeps the state of all blocks.
+func (h *Handler) SetArchiveNode(archive bool) {
+	h.archiveNode = archive
+}
+
+// SetDepositWallet sets the wallet, from which deposit addresses
+// are derived.
+func (h *Handler) SetDepositWallet(wallet *eth.DepositWallet) {
+	h.depositWallet = wallet
+}
+
+// SetNonceManager sets the nonce manager of the client, so that sends
+// hold the nonce lock of the sender until the output is stored.
+func (h *Handler) SetNonceManager(nonces *eth.NonceManager) {
+	h.nonces = nonces
+}
+
+// lockNonce runs the function holding the nonce lock of the account.
+// Without a nonce manager it runs the function in a database transaction.
+func (h *Handler) lockNonce(ctx context.Context, account common.Address,
+	fn func(ctx context.Context, q *reform.Querier) error) error {
+	return eth.LockNonce(ctx, h.nonces, h.database, account, fn)
+}
+
+// SetScheduler sets the scheduler, which state is reported by Status.
+func (h *Handler) SetScheduler(scheduler *proc.Scheduler) {
+	h.scheduler = scheduler
+}
+
+// Status returns node sync progress, the collector lag, database
+// reachability and the last errors of the scheduler tasks.
+func (h *Handler) Status(ctx context.Context) (*proc.Status, error) {
+	if h.scheduler == nil {
+		return nil, errors.New("scheduler is not running")
+	}
+	return h.scheduler.Status(ctx), nil
+}
+
+// Tasks returns states of the scheduler tasks.
+func (h *Handler) Tasks() ([]proc.TaskStatus, error) {
+	if h.scheduler == nil {
+		return nil, errors.New("scheduler is not running")
+	}
+	return h.scheduler.Tasks(), nil
+}
+
+// StartTask starts a stopped scheduler task.
+func (h *Handler) StartTask(name string) error {
+	if h.scheduler == nil {
+		return errors.New("scheduler is not running")
+	}
+	return h.scheduler.StartTask(name)
+}
+
+// StopTask stops a scheduler task.
+func (h *Handler) StopTask(name string) error {
+	if h.scheduler == nil {
+		return errors.New("scheduler is not running")
+	}
+	return h.scheduler.StopTask(name)
+}
+
 // GetLast returns latest transactions.
 func (h *Handler) GetLast(limit uint64) ([]GetLastResult, error) {
 	h.mtx.Lock()
@@ -57,14 +220,15 @@ func (h *Handler) GetLast(limit uint64) ([]GetLastResult, error) {
 
 	query := `WHERE transactions."to" 
 				 IN (SELECT public_key FROM accounts) 
-				AND (confirmations < %s OR NOT marked)
+				AND (%s OR NOT marked)
 			  ORDER BY block ASC LIMIT %s`
 
-	tail := fmt.Sprintf(query,
-		h.database.Placeholder(1), h.database.Placeholder(2))
+	notFinal, args := h.finality.NotFinal(h.database.Placeholder, 1, false)
+	tail := fmt.Sprintf(query, notFinal,
+		h.database.Placeholder(len(args)+1))
 
 	items, err := h.database.SelectAllFrom(
-		data.TransactionTable, tail, 3, limit)
+		data.TransactionTable, tail, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -90,19 +254,195 @@ func (h *Handler) GetLast(limit uint64) ([]GetLastResult, error) {
 			Address:       tx.To,
 			Amount:        tx.Amount,
 			Confirmations: tx.Confirmations,
+			Final: h.finality.Final("", tx.Amount,
+				tx.Confirmations),
+			TracePath:         tx.TracePath,
+			GasLimit:          tx.GasLimit,
+			GasUsed:           tx.GasUsed,
+			EffectiveGasPrice: tx.EffectiveGasPrice,
+			Fee:               tx.Fee,
+			BurnedFee:         tx.BurnedFee,
+			TipFee:            tx.TipFee,
+			TxType:            tx.TxType,
+		}
+	}
+
+	return result, nil
+}
+
+// GetLastTokens returns latest ERC-20 token transfers.
+func (h *Handler) GetLastTokens(limit uint64) ([]GetLastTokensResult, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	query := `WHERE token_transfers."to"
+				 IN (SELECT public_key FROM accounts)
+				AND (%s OR NOT marked)
+			  ORDER BY block ASC, log_index ASC LIMIT %s`
+
+	notFinal, args := h.finality.NotFinal(h.database.Placeholder, 1, true)
+	tail := fmt.Sprintf(query, notFinal,
+		h.database.Placeholder(len(args)+1))
+
+	items, err := h.database.SelectAllFrom(
+		data.TokenTransferTable, tail, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]GetLastTokensResult, len(items))
+
+	for k, item := range items {
+		transfer := *item.(*data.TokenTransfer)
+		tm := time.Unix(0, 0)
+
+		if transfer.Timestamp != nil {
+			tm = time.Unix(int64(*transfer.Timestamp), 0)
+		}
+
+		transfer.Marked = true
+		if err := h.database.Save(&transfer); err != nil {
+			return nil, err
+		}
+
+		result[k] = GetLastTokensResult{
+			Hash:          transfer.Hash,
+			LogIndex:      transfer.LogIndex,
+			Date:          tm.Format(time.RFC3339),
+			Token:         transfer.Token,
+			Address:       transfer.To,
+			Amount:        transfer.Amount,
+			Confirmations: transfer.Confirmations,
+			Final: h.finality.Final(transfer.Token, transfer.Amount,
+				transfer.Confirmations),
 		}
 	}
 
 	return result, nil
 }
 
-// SendETH sends ETH to specific address.
-func (h *Handler) SendETH(from, to, amount string) (*string, error) {
+// NewAccount creates a new account on Geth node protected by
+// the password and stores it as a managed account. The account is
+// locked, it must be unlocked before sending.
+func (h *Handler) NewAccount(password string) (*string, error) {
+	if password == "" {
+		return nil, errors.New(`empty "password" argument`)
+	}
+
+	addr, err := h.ethClient.NewAccount(context.Background(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &data.Account{
+		ID:        h.genUUIDFunc(),
+		Balance:   "0",
+		PublicKey: strings.ToLower(addr.String()),
+		Kind:      data.AccountNode,
+	}
+
+	if err := h.database.Insert(account); err != nil {
+		return nil, err
+	}
+
+	return &account.PublicKey, nil
+}
+
+// UnlockAccount unlocks an account on Geth node for the duration
+// in seconds. If the duration is omitted, Geth default (300 seconds)
+// is used, zero duration unlocks the account until Geth exits.
+func (h *Handler) UnlockAccount(account, password string,
+	duration *uint64) error {
+	if !common.IsHexAddress(account) {
+		return errors.New(`invalid "account" argument`)
+	}
+
+	return h.ethClient.UnlockAccount(context.Background(),
+		common.HexToAddress(account), password, duration)
+}
+
+// LockAccount locks an account on Geth node.
+func (h *Handler) LockAccount(account string) error {
+	if !common.IsHexAddress(account) {
+		return errors.New(`invalid "account" argument`)
+	}
+
+	return h.ethClient.LockAccount(context.Background(),
+		common.HexToAddress(account))
+}
+
+// WatchAddress stores a watch-only address with an optional label.
+// Transactions of the address are collected like transactions of node
+// accounts. The label of an already watched address is updated.
+func (h *Handler) WatchAddress(address string, label *string) error {
+	if !common.IsHexAddress(address) {
+		return errors.New(`invalid "address" argument`)
+	}
+
+	address = strings.ToLower(address)
+
+	account := &data.Account{}
+	err := h.database.FindOneTo(account, "public_key", address)
+	if err != nil && err != reform.ErrNoRows {
+		return err
+	}
+
+	if err == nil {
+		if account.Kind != data.AccountWatch {
+			return errors.Errorf("address is already managed as %s account",
+				account.Kind)
+		}
+
+		account.Label = label
+		return h.database.Save(account)
+	}
+
+	balance, err := h.ethClient.BalanceAt(context.Background(),
+		common.HexToAddress(address), nil)
+	if err != nil {
+		return err
+	}
+
+	return h.database.Insert(&data.Account{
+		ID:        h.genUUIDFunc(),
+		Balance:   balance.String(),
+		PublicKey: address,
+		Kind:      data.AccountWatch,
+		Label:     label,
+	})
+}
+
+// UnwatchAddress removes a watch-only address. Collected transactions
+// of the address are kept.
+func (h *Handler) UnwatchAddress(address string) error {
+	if !common.IsHexAddress(address) {
+		return errors.New(`invalid "address" argument`)
+	}
+
+	tail := fmt.Sprintf("WHERE public_key = %s AND kind = %s",
+		h.database.Placeholder(1), h.database.Placeholder(2))
+
+	removed, err := h.database.DeleteFrom(data.AccountTable, tail,
+		strings.ToLower(address), data.AccountWatch)
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		return errors.New("watch-only address not found")
+	}
+	return nil
+}
+
+// SendETH sends ETH to specific address. Optional fee caps
+// (in Wei) limit the cost of the transaction.
+func (h *Handler) SendETH(from, to, amount string,
+	maxFeePerGas, maxPriorityFeePerGas *string) (*string, error) {
 	if !common.IsHexAddress(from) {
 		return nil, errors.New(`invalid "from" argument`)
 	}
 
-	if !common.IsHexAddress(from) {
+	if !common.IsHexAddress(to) {
 		return nil, errors.New(`invalid "to" argument`)
 	}
 
@@ -111,21 +451,345 @@ func (h *Handler) SendETH(from, to, amount string) (*string, error) {
 		return nil, errors.New(`invalid "amount" argument`)
 	}
 
-	hash, err := h.ethClient.SendTransaction(context.Background(),
-		common.HexToAddress(from), common.HexToAddress(to), val)
+	caps, err := parseFeeCaps(maxFeePerGas, maxPriorityFeePerGas)
 	if err != nil {
 		return nil, err
 	}
 
-	output := &data.Output{
-		ID:      gen.NewUUID(),
-		Hash:    *hash,
-		Account: strings.ToLower(from),
-	}
+	var hash *string
 
-	if err := h.database.Save(output); err != nil {
+	// The output is stored before other transactions of the sender
+	// are sent, so that the sweeper sees the reserved funds.
+	err = h.lockNonce(context.Background(), common.HexToAddress(from),
+		func(ctx context.Context, q *reform.Querier) error {
+			hash, err = h.ethClient.SendTransaction(ctx,
+				common.HexToAddress(from), common.HexToAddress(to), val,
+				caps)
+			if err != nil {
+				return err
+			}
+
+			return q.Save(&data.Output{
+				ID:      gen.NewUUID(),
+				Hash:    *hash,
+				Account: strings.ToLower(from),
+				SentAt:  pointer.ToUint64(uint64(time.Now().Unix())),
+				Status:  data.OutputPending,
+				Kind:    data.OutputWithdrawal,
+				Amount:  pointer.ToString(val.String()),
+			})
+		})
+	if err != nil {
 		return nil, err
 	}
 
 	return hash, nil
 }
+
+// GetWithdrawal returns the state of an outgoing transaction.
+func (h *Handler) GetWithdrawal(hash string) (*GetWithdrawalResult, error) {
+	if !isHexHash(hash) {
+		return nil, errors.New(`invalid "hash" argument`)
+	}
+
+	output := &data.Output{}
+	if err := h.database.FindOneTo(output, "hash",
+		strings.ToLower(hash)); err != nil {
+		if err == reform.ErrNoRows {
+			return nil, errors.New("outgoing transaction not found")
+		}
+		return nil, err
+	}
+
+	result := &GetWithdrawalResult{
+		Hash:              output.Hash,
+		Account:           output.Account,
+		Kind:              output.Kind,
+		Amount:            output.Amount,
+		Status:            output.Status,
+		MinedHash:         output.MinedHash,
+		Block:             output.Block,
+		GasUsed:           output.GasUsed,
+		EffectiveGasPrice: output.EffectiveGasPrice,
+		Confirmations:     output.Confirmations,
+		SentAt:            formatTime(output.SentAt),
+		MinedAt:           formatTime(output.MinedAt),
+	}
+
+	if output.ReplacedBy != nil {
+		replacement := &data.Output{}
+		if err := h.database.FindByPrimaryKeyTo(replacement,
+			*output.ReplacedBy); err != nil {
+			return nil, err
+		}
+		result.ReplacedBy = &replacement.Hash
+	}
+
+	if output.MinedHash != nil && *output.MinedHash == output.Hash {
+		tx := &data.Transaction{}
+		tail := fmt.Sprintf("WHERE hash = %s AND trace_path IS NULL",
+			h.database.Placeholder(1))
+
+		err := h.database.SelectOneTo(tx, tail, output.Hash)
+		if err != nil && err != reform.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			result.Fee = tx.Fee
+			result.BurnedFee = tx.BurnedFee
+			result.TipFee = tx.TipFee
+		}
+	}
+
+	return result, nil
+}
+
+func formatTime(unix *uint64) *string {
+	if unix == nil {
+		return nil
+	}
+	return pointer.ToString(time.Unix(int64(*unix), 0).Format(time.RFC3339))
+}
+
+// SpeedUp replaces a pending outgoing transaction with the same
+// transaction with bumped fees. Optional fee caps (in Wei) limit
+// the fees of the replacement. Returns the hash of the replacement.
+func (h *Handler) SpeedUp(hash string,
+	maxFeePerGas, maxPriorityFeePerGas *string) (*string, error) {
+	return h.replace(hash, false, maxFeePerGas, maxPriorityFeePerGas)
+}
+
+// Cancel replaces a pending outgoing transaction with a zero-value
+// transfer to the sender with bumped fees. Optional fee caps (in Wei)
+// limit the fees of the replacement. Returns the hash of the replacement.
+func (h *Handler) Cancel(hash string,
+	maxFeePerGas, maxPriorityFeePerGas *string) (*string, error) {
+	return h.replace(hash, true, maxFeePerGas, maxPriorityFeePerGas)
+}
+
+func (h *Handler) replace(hash string, cancel bool,
+	maxFeePerGas, maxPriorityFeePerGas *string) (*string, error) {
+	if !isHexHash(hash) {
+		return nil, errors.New(`invalid "hash" argument`)
+	}
+
+	caps, err := parseFeeCaps(maxFeePerGas, maxPriorityFeePerGas)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := eth.ReplaceOutput(context.Background(), h.database,
+		h.nonces, h.ethClient, hash, cancel, caps)
+	if err != nil {
+		if err == reform.ErrNoRows {
+			return nil, errors.New("outgoing transaction not found")
+		}
+		return nil, err
+	}
+
+	return &output.Hash, nil
+}
+
+func isHexHash(hash string) bool {
+	b, err := hexutil.Decode(hash)
+	return err == nil && len(b) == common.HashLength
+}
+
+func parseFeeCaps(maxFeePerGas,
+	maxPriorityFeePerGas *string) (*eth.FeeCaps, error) {
+	caps := &eth.FeeCaps{}
+	success := true
+
+	if maxFeePerGas != nil {
+		caps.MaxFeePerGas, success = new(big.Int).SetString(*maxFeePerGas, 10)
+		if !success {
+			return nil, errors.New(`invalid "maxFeePerGas" argument`)
+		}
+	}
+
+	if maxPriorityFeePerGas != nil {
+		caps.MaxPriorityFeePerGas, success = new(big.Int).SetString(
+			*maxPriorityFeePerGas, 10)
+		if !success {
+			return nil, errors.New(`invalid "maxPriorityFeePerGas" argument`)
+		}
+	}
+
+	return caps, nil
+}
+
+// NewDepositAddress derives the next deposit address from the extended
+// public key and stores it as a managed account.
+func (h *Handler) NewDepositAddress() (*NewDepositAddressResult, error) {
+	if h.depositWallet == nil {
+		return nil, errors.New("deposit addresses are not configured")
+	}
+
+	var result *NewDepositAddressResult
+
+	err := h.database.InTransaction(func(t *reform.TX) error {
+		setting := &data.Setting{Key: nextDerivationIndex, Value: "0"}
+
+		// The setting is locked until the account is stored,
+		// so concurrent calls get different indexes.
+		tail := fmt.Sprintf("WHERE key = %s FOR UPDATE",
+			h.database.Placeholder(1))
+
+		err := t.SelectOneTo(setting, tail, nextDerivationIndex)
+		if err != nil && err != reform.ErrNoRows {
+			return err
+		}
+
+		index, err := strconv.ParseUint(setting.Value, 10, 32)
+		if err != nil {
+			return err
+		}
+
+		address, path, err := h.depositWallet.Derive(uint32(index))
+		for err == eth.ErrInvalidChild {
+			index++
+			address, path, err = h.depositWallet.Derive(uint32(index))
+		}
+		if err != nil {
+			return err
+		}
+
+		account := &data.Account{
+			ID:             h.genUUIDFunc(),
+			Balance:        "0",
+			PublicKey:      strings.ToLower(address.String()),
+			DerivationPath: pointer.ToString(path),
+			Kind:           data.AccountDerived,
+		}
+
+		if err := t.Insert(account); err != nil {
+			return err
+		}
+
+		setting.Value = strconv.FormatUint(index+1, 10)
+		if err := t.Save(setting); err != nil {
+			return err
+		}
+
+		result = &NewDepositAddressResult{
+			Address: account.PublicKey,
+			Path:    path,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetBalanceHistory returns the balance of an account at the start of
+// the period and all changes of the balance within the period. The dates
+// are in RFC3339 format. If the start balance has not been captured,
+// it is read from the archive node, if it is enabled. Only the start
+// point is read from the node, changes made before the account has been
+// managed are not in the history.
+func (h *Handler) GetBalanceHistory(address, from,
+	to string) ([]BalanceHistoryPoint, error) {
+	if !common.IsHexAddress(address) {
+		return nil, errors.New(`invalid "address" argument`)
+	}
+
+	fromTime, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return nil, errors.New(`invalid "from" argument`)
+	}
+
+	toTime, err := time.Parse(time.RFC3339, to)
+	if err != nil || toTime.Before(fromTime) {
+		return nil, errors.New(`invalid "to" argument`)
+	}
+
+	address = strings.ToLower(address)
+
+	if err := h.database.FindOneTo(&data.Account{}, "public_key",
+		address); err != nil {
+		if err == reform.ErrNoRows {
+			return nil, errors.New("account not found")
+		}
+		return nil, err
+	}
+
+	// Balances are stored since the account is managed, every change is
+	// stored, so the last balance before the period is the start balance.
+	start := &data.Balance{}
+	tail := fmt.Sprintf("WHERE account = %s AND timestamp <= %s"+
+		" ORDER BY block DESC LIMIT 1", h.database.Placeholder(1),
+		h.database.Placeholder(2))
+
+	err = h.database.SelectOneTo(start, tail, address, fromTime.Unix())
+	if err != nil && err != reform.ErrNoRows {
+		return nil, err
+	}
+
+	if err == reform.ErrNoRows {
+		start = nil
+		if h.archiveNode {
+			if start, err = h.historicalBalance(address,
+				uint64(fromTime.Unix())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tail = fmt.Sprintf("WHERE account = %s AND timestamp > %s"+
+		" AND timestamp <= %s ORDER BY block", h.database.Placeholder(1),
+		h.database.Placeholder(2), h.database.Placeholder(3))
+
+	items, err := h.database.SelectAllFrom(data.BalanceTable, tail,
+		address, fromTime.Unix(), toTime.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	var balances []*data.Balance
+	if start != nil {
+		balances = append(balances, start)
+	}
+	for k := range items {
+		balances = append(balances, items[k].(*data.Balance))
+	}
+
+	result := make([]BalanceHistoryPoint, len(balances))
+	for k, balance := range balances {
+		result[k] = BalanceHistoryPoint{
+			Date: time.Unix(int64(balance.Timestamp),
+				0).Format(time.RFC3339),
+			Block:   balance.Block,
+			Balance: balance.Balance,
+		}
+	}
+
+	return result, nil
+}
+
+// historicalBalance reads a balance at the time from the archive node.
+// It is not stored, because the changes before it are unknown.
+func (h *Handler) historicalBalance(address string,
+	timestamp uint64) (*data.Balance, error) {
+	ctx := context.Background()
+
+	block, err := eth.BlockByTime(ctx, h.ethClient, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := h.ethClient.BalanceAt(ctx,
+		common.HexToAddress(address), block.Number())
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Balance{
+		Account:   address,
+		Block:     block.NumberU64(),
+		Timestamp: block.Time(),
+		Balance:   balance.String(),
+	}, nil
+}
@@ -15,9 +15,8 @@ import (
 	"github.com/AlekSi/pointer"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/crypto"
 	"gopkg.in/reform.v1"
 
@@ -28,38 +27,30 @@ import (
 	"github.com/dzeckelev/geth-wrapper/gen"
 )
 
-const gasLimit uint64 = 4700000
-
 var (
 	ethClient *eth.MockClient
 	columns   = []string{"o_id", "o_hash", "o_from", "o_to", "o_amount",
-		"o_status", "o_block", "o_timestamp", "o_marked", "o_confirmations"}
-	network = big.NewInt(4)
+		"o_status", "o_block", "o_timestamp", "o_marked", "o_confirmations",
+		"o_trace_path", "o_gas_limit", "o_gas_used", "o_effective_gas_price",
+		"o_fee", "o_burned_fee", "o_tip_fee", "o_tx_type"}
+	transferColumns = []string{"o_id", "o_hash", "o_token", "o_log_index",
+		"o_from", "o_to", "o_amount", "o_block", "o_timestamp", "o_marked",
+		"o_confirmations"}
+	network = eth.MockChainID
 )
 
 func genNewAccount() *bind.TransactOpts {
 	key, _ := crypto.GenerateKey()
-	return bind.NewKeyedTransactor(key)
+	opts, _ := bind.NewKeyedTransactorWithChainID(key, eth.MockChainID)
+	return opts
 }
 
 func newEthClient() *eth.MockClient {
-	opts := genNewAccount()
-	addr := strings.ToLower(opts.From.String())
-
 	balance := "1000000000000000000000000000000000000000000000000000"
 	b1 := new(big.Int)
 	_, _ = fmt.Sscan(balance, b1)
 
-	alloc := make(core.GenesisAlloc)
-	alloc[opts.From] = core.GenesisAccount{Balance: b1}
-	sim := backends.NewSimulatedBackend(alloc, gasLimit)
-
-	return &eth.MockClient{
-		Acc:     map[string]*bind.TransactOpts{addr: opts},
-		NetID:   big.NewInt(4),
-		Block:   nil,
-		Backend: sim,
-	}
+	return eth.NewMockClient(b1, genNewAccount())
 }
 
 func newDB(t *testing.T) (*reform.DB, sqlmock.Sqlmock) {
@@ -95,6 +86,14 @@ func newTestTx() *data.Transaction {
 		Block:         block,
 		Timestamp:     timestamp,
 		Confirmations: confirmations,
+		GasLimit:      pointer.ToUint64(25200),
+		GasUsed:       pointer.ToUint64(21000),
+		// Base fee 10 Gwei, tip 2 Gwei.
+		EffectiveGasPrice: pointer.ToString("12000000000"),
+		Fee:               pointer.ToString("252000000000000"),
+		BurnedFee:         pointer.ToString("210000000000000"),
+		TipFee:            pointer.ToString("42000000000000"),
+		TxType:            pointer.ToUint64(2),
 	}
 }
 
@@ -105,7 +104,24 @@ func createTestTxs(num int) (result []*data.Transaction) {
 	return result
 }
 
-func toRow(tx *data.Transaction) (result []driver.Value) {
+func newTestTokenTransfer(logIndex uint64) *data.TokenTransfer {
+	tx := newTestTx()
+
+	return &data.TokenTransfer{
+		ID:            gen.NewUUID(),
+		Hash:          tx.Hash,
+		Token:         "0xdac17f958d2ee523a2206206994597c13d831ec7",
+		LogIndex:      logIndex,
+		From:          tx.From,
+		To:            tx.To,
+		Amount:        tx.Amount,
+		Block:         tx.Block,
+		Timestamp:     tx.Timestamp,
+		Confirmations: tx.Confirmations,
+	}
+}
+
+func toRow(tx reform.Struct) (result []driver.Value) {
 	result = make([]driver.Value, len(tx.Values()))
 
 	for k, v := range tx.Values() {
@@ -179,6 +195,11 @@ func TestGetLast(t *testing.T) {
 		checkFiled(t, result[k].Amount, txs[k].Amount)
 		checkFiled(t, result[k].Hash, txs[k].Hash)
 		checkFiled(t, result[k].Address, txs[k].To)
+		checkFiled(t, result[k].GasUsed, txs[k].GasUsed)
+		checkFiled(t, result[k].Fee, txs[k].Fee)
+		checkFiled(t, result[k].BurnedFee, txs[k].BurnedFee)
+		checkFiled(t, result[k].TipFee, txs[k].TipFee)
+		checkFiled(t, result[k].TxType, txs[k].TxType)
 		checkDate(t, result[k].Date, txs[k].Timestamp)
 	}
 
@@ -187,6 +208,52 @@ func TestGetLast(t *testing.T) {
 	}
 }
 
+func TestGetLastTokens(t *testing.T) {
+	dataBase, sqlMock := newDB(t)
+	handler := api.NewHandler(network, dataBase, ethClient, nil)
+
+	limit := uint64(100)
+	confirmations := uint64(3)
+
+	transfers := []*data.TokenTransfer{
+		newTestTokenTransfer(0), newTestTokenTransfer(1)}
+
+	expSelectSQL := `SELECT (.+) FROM "token_transfers"`
+	expUpdateSQL := `UPDATE "token_transfers"`
+
+	sqlMock.ExpectQuery(expSelectSQL).
+		WithArgs(confirmations, limit).
+		WillReturnRows(sqlmock.NewRows(transferColumns).
+			AddRow(toRow(transfers[0])...).AddRow(toRow(transfers[1])...))
+
+	for range transfers {
+		sqlMock.ExpectExec(expUpdateSQL).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	result, err := handler.GetLastTokens(limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != len(transfers) {
+		t.Fatalf("expected %v, got %v", len(transfers), len(result))
+	}
+
+	for k := range result {
+		checkFiled(t, result[k].Token, transfers[k].Token)
+		checkFiled(t, result[k].LogIndex, transfers[k].LogIndex)
+		checkFiled(t, result[k].Amount, transfers[k].Amount)
+		checkFiled(t, result[k].Hash, transfers[k].Hash)
+		checkFiled(t, result[k].Address, transfers[k].To)
+		checkDate(t, result[k].Date, transfers[k].Timestamp)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestHandlerSendETH(t *testing.T) {
 	dataBase, sqlMock := newDB(t)
 	handler := api.NewHandler(network, dataBase, ethClient, gen.NewUUID)
@@ -196,15 +263,26 @@ func TestHandlerSendETH(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	handler.SetNonceManager(eth.NewNonceManager(dataBase, ethClient))
+
 	opts := genNewAccount()
 	to := strings.ToLower(opts.From.String())
+	from := strings.ToLower(accounts[0])
 
 	expUpdateSQL := `UPDATE "outputs"`
 
+	// The output is stored holding the nonce lock of the sender.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`INSERT INTO nonces`).WithArgs(from).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "nonces" (.+) FOR UPDATE`).
+		WithArgs(from).WillReturnRows(
+		sqlmock.NewRows([]string{"account", "next"}).AddRow(from, 0))
 	sqlMock.ExpectExec(expUpdateSQL).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	sqlMock.ExpectCommit()
 
-	hash, err := handler.SendETH(accounts[0], to, "10000")
+	hash, err := handler.SendETH(accounts[0], to, "10000", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -219,6 +297,215 @@ func TestHandlerSendETH(t *testing.T) {
 	}
 }
 
+func TestHandlerNewDepositAddress(t *testing.T) {
+	dataBase, sqlMock := newDB(t)
+	handler := api.NewHandler(network, dataBase, ethClient, gen.NewUUID)
+
+	if _, err := handler.NewDepositAddress(); err == nil {
+		t.Fatal("expected error without deposit wallet")
+	}
+
+	xpub := "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV"
+	wallet, err := eth.NewDepositWallet(xpub, "m/0'/1/2'/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetDepositWallet(wallet)
+
+	address, path, err := wallet.Derive(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "settings" (.+) FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).
+			AddRow("nextDerivationIndex", "5"))
+	sqlMock.ExpectQuery(`INSERT INTO "accounts"`).
+		WithArgs(sqlmock.AnyArg(), "0", strings.ToLower(address.String()),
+			path, data.AccountDerived, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+		AddRow(gen.NewUUID()))
+	sqlMock.ExpectExec(`UPDATE "settings"`).WithArgs("6",
+		"nextDerivationIndex").WillReturnResult(sqlmock.NewResult(1, 1))
+	sqlMock.ExpectCommit()
+
+	result, err := handler.NewDepositAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiled(t, strings.ToLower(address.String()), result.Address)
+	checkFiled(t, "m/0'/1/2'/2/0/5", result.Path)
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandlerAccountManagement(t *testing.T) {
+	dataBase, sqlMock := newDB(t)
+	handler := api.NewHandler(network, dataBase, newEthClient(),
+		gen.NewUUID)
+
+	if _, err := handler.NewAccount(""); err == nil {
+		t.Fatal("expected error of empty password")
+	}
+
+	sqlMock.ExpectQuery(`INSERT INTO "accounts"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).
+			AddRow(gen.NewUUID()))
+
+	account, err := handler.NewAccount("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	if err := handler.UnlockAccount(*account, "wrong", nil); err == nil {
+		t.Fatal("expected error of wrong password")
+	}
+
+	if err := handler.UnlockAccount(*account, "secret",
+		pointer.ToUint64(60)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler.LockAccount(*account); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler.LockAccount("invalid"); err == nil {
+		t.Fatal("expected error of invalid account")
+	}
+}
+
+func TestHandlerWatchAddress(t *testing.T) {
+	dataBase, sqlMock := newDB(t)
+	handler := api.NewHandler(network, dataBase, ethClient, gen.NewUUID)
+
+	address := "0xa7dba6053a0d631177340e8061bc12f5009ba453"
+	label := pointer.ToString("cold wallet")
+
+	accountColumns := []string{"id", "balance", "public_key",
+		"derivation_path", "kind", "label"}
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "accounts"`).
+		WithArgs(address).WillReturnRows(sqlmock.NewRows(accountColumns))
+	sqlMock.ExpectQuery(`INSERT INTO "accounts"`).
+		WithArgs(sqlmock.AnyArg(), "0", address, nil, data.AccountWatch,
+			*label).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+		AddRow(gen.NewUUID()))
+
+	if err := handler.WatchAddress(address, label); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node accounts can not be watched.
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "accounts"`).
+		WithArgs(address).WillReturnRows(sqlmock.NewRows(accountColumns).
+		AddRow(gen.NewUUID(), "0", address, nil, data.AccountNode, nil))
+
+	if err := handler.WatchAddress(address, nil); err == nil {
+		t.Fatal("expected error of a node account")
+	}
+
+	sqlMock.ExpectExec(`DELETE FROM "accounts"`).
+		WithArgs(address, data.AccountWatch).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := handler.UnwatchAddress(address); err != nil {
+		t.Fatal(err)
+	}
+
+	sqlMock.ExpectExec(`DELETE FROM "accounts"`).
+		WithArgs(address, data.AccountWatch).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := handler.UnwatchAddress(address); err == nil {
+		t.Fatal("expected error of unknown address")
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandlerGetBalanceHistory(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	opts := genNewAccount()
+	client := eth.NewMockClient(big.NewInt(1e18), opts)
+
+	handler := api.NewHandler(network, dataBase, client, gen.NewUUID)
+	handler.SetArchiveNode(true)
+
+	address := "0xa7dba6053a0d631177340e8061bc12f5009ba453"
+
+	if _, err := client.SendTransaction(ctx, opts.From,
+		common.HexToAddress(address), big.NewInt(1000), nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Mine()
+
+	block, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(int64(block.Time())+1, 0)
+	end := start.Add(time.Hour)
+
+	stored := &data.Balance{
+		ID:        gen.NewUUID(),
+		Account:   address,
+		Block:     5,
+		Timestamp: uint64(start.Unix()) + 60,
+		Balance:   "3000",
+	}
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "accounts"`).
+		WithArgs(address).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "balance", "public_key", "derivation_path", "kind",
+			"label"}).
+		AddRow(gen.NewUUID(), "3000", address, nil, data.AccountWatch, nil))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "balances" WHERE (.+) DESC`).
+		WithArgs(address, start.Unix()).
+		WillReturnRows(sqlmock.NewRows(stored.Table().Columns()))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "balances"`).
+		WithArgs(address, start.Unix(), end.Unix()).
+		WillReturnRows(sqlmock.NewRows(stored.Table().Columns()).
+			AddRow(toRow(stored)...))
+
+	result, err := handler.GetBalanceHistory(address,
+		start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []api.BalanceHistoryPoint{
+		{
+			Date:    time.Unix(int64(block.Time()), 0).Format(time.RFC3339),
+			Block:   1,
+			Balance: "1000",
+		},
+		{
+			Date: time.Unix(int64(stored.Timestamp),
+				0).Format(time.RFC3339),
+			Block:   5,
+			Balance: "3000",
+		},
+	}
+
+	checkFiled(t, exp, result)
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestMain(m *testing.M) {
 	ethClient = newEthClient()
 	os.Exit(m.Run())
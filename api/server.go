@@ -2,8 +2,11 @@ package api
 
 import (
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/proc"
 
 	"github.com/ethereum/go-ethereum/rpc"
 )
@@ -12,24 +15,64 @@ import (
 type Server struct {
 	rpcSrv  *rpc.Server
 	httpSrv *http.Server
+
+	mtx       sync.RWMutex
+	scheduler *proc.Scheduler
 }
 
 // NewServer creates a new API server.
 func NewServer(cfg *config.Config) (*Server, error) {
 	rpcSrv := rpc.NewServer()
 
+	srv := &Server{rpcSrv: rpcSrv}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", rpcSrv.ServeHTTP)
+	mux.HandleFunc("/healthz", srv.healthz)
+	mux.HandleFunc("/readyz", srv.readyz)
 
-	httpSrv := &http.Server{
+	srv.httpSrv = &http.Server{
 		Addr:    cfg.API.Addr,
 		Handler: mux,
 	}
 
-	return &Server{
-		rpcSrv:  rpcSrv,
-		httpSrv: httpSrv,
-	}, nil
+	return srv, nil
+}
+
+// SetScheduler sets the scheduler, which state is checked by /readyz.
+func (s *Server) SetScheduler(scheduler *proc.Scheduler) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.scheduler = scheduler
+}
+
+// healthz reports that the server is alive.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the service is ready: the node is synced,
+// the database is reachable and the collector is not behind.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mtx.RLock()
+	scheduler := s.scheduler
+	s.mtx.RUnlock()
+
+	if scheduler == nil {
+		http.Error(w, "scheduler is not running",
+			http.StatusServiceUnavailable)
+		return
+	}
+
+	status := scheduler.Status(r.Context())
+	if !status.Ready {
+		http.Error(w, strings.Join(status.NotReady, "\n"),
+			http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
 }
 
 // AddHandler registers a new RPC handler.
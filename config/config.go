@@ -1,17 +1,97 @@
 package config
 
+import (
+	"encoding/json"
+	"math/big"
+)
+
 // Config is an application configuration.
 type Config struct {
-	API  *API
-	DB   *DB
-	Eth  *Eth
-	Proc *Proc
+	API     *API
+	DB      *DB
+	Eth     *Eth
+	Proc    *Proc
+	Webhook *Webhook
+	Sweep   *Sweep
 }
 
 // Eth is a communication configuration with Ethereum.
 type Eth struct {
-	NodeURL    string
+	// NodeURL is a list of Ethereum nodes. Calls are routed to the best
+	// healthy node, the other nodes are used for failover.
+	NodeURL    URLs
 	StartBlock uint64
+	// ChainID is an expected chain ID of the nodes. If it is zero,
+	// the chain ID of the first available node is used.
+	ChainID uint64
+	// HealthCheckPause is a pause between node health checks.
+	HealthCheckPause uint64 // In milliseconds.
+	// MaxHeadAge is a maximum age of the last block of a healthy node.
+	MaxHeadAge uint64 // In seconds.
+	// LegacyGasPrice disables EIP-1559 transactions,
+	// the gas price is taken from eth_gasPrice.
+	LegacyGasPrice bool
+	// FeeHistoryBlocks is a number of recent blocks
+	// used to suggest the priority fee.
+	FeeHistoryBlocks uint64
+	// FeeHistoryPercentile is a percentile of priority fees
+	// paid in a block, which is used as a sample.
+	FeeHistoryPercentile float64
+	// GasMultiplier is a safety multiplier of the estimated gas.
+	GasMultiplier float64
+	// GasLimitCap is an upper limit of gas of an outgoing transfer.
+	// Zero means no limit.
+	GasLimitCap uint64
+	// FeeBumpPercent is a minimal fee increase of a replacement
+	// transaction. Geth requires at least 10 percent.
+	FeeBumpPercent uint64
+	// KeystoreDir is a directory with encrypted key files. Transactions
+	// from these accounts are signed locally. Optional.
+	KeystoreDir string
+	// KeystorePasswordFile is a file with the password
+	// of the keystore accounts.
+	KeystorePasswordFile string
+	// XPub is a BIP-32 extended public key, from which deposit
+	// addresses are derived. Optional.
+	XPub string
+	// XPubPath is a derivation path of XPub. Deposit addresses
+	// have paths XPubPath/0/<index>.
+	XPubPath string
+	// RequestsPerSecond limits the rate of requests to the nodes.
+	// Zero means no limit.
+	RequestsPerSecond float64
+	// RetryAttempts is a number of retries of a request,
+	// which failed with a transient error.
+	RetryAttempts uint64
+	// RetryMinBackoff is an upper bound of the delay before
+	// the first retry, it doubles with every retry.
+	RetryMinBackoff uint64 // In milliseconds.
+	// RetryMaxBackoff is a maximum delay before a retry.
+	RetryMaxBackoff uint64 // In milliseconds.
+	// ArchiveNode enables reading historical balances,
+	// the nodes must keep the state of all blocks.
+	ArchiveNode bool
+}
+
+// URLs is a list of URLs. In JSON it is either a string
+// or an array of strings.
+type URLs []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (u *URLs) UnmarshalJSON(b []byte) error {
+	var url string
+	if err := json.Unmarshal(b, &url); err == nil {
+		*u = URLs{url}
+		return nil
+	}
+
+	var urls []string
+	if err := json.Unmarshal(b, &urls); err != nil {
+		return err
+	}
+
+	*u = urls
+	return nil
 }
 
 // DB is a database configuration.
@@ -35,6 +115,93 @@ type Proc struct {
 	CollectPause            uint64 // In milliseconds.
 	UpdateTransactionsPause uint64 // In milliseconds.
 	SyncPause               uint64 // In milliseconds.
+	UpdateOutputsPause      uint64 // In milliseconds.
+	// SpeedUpAfter is a time after which a pending outgoing transaction
+	// is sped up automatically. Zero disables automatic speed-ups.
+	SpeedUpAfter uint64 // In seconds.
+	// SpeedUpMaxFeePerGas limits the fee per gas of automatic speed-ups,
+	// in Wei. Nil value means no limit.
+	SpeedUpMaxFeePerGas *big.Int
+	// DropOutputAfter is a time after which a pending outgoing
+	// transaction missing from the mempool is marked as dropped.
+	DropOutputAfter uint64 // In seconds.
+	// TraceInternalTxs enables detection of ETH transfers made by
+	// contract internal calls. It requires the debug API on the node.
+	TraceInternalTxs bool
+	// MaxReorgDepth is the maximum number of blocks the collector
+	// walks back looking for a common ancestor after a reorganization.
+	MaxReorgDepth uint64
+	// ReadyMaxLag is a maximum number of blocks the collector can be
+	// behind the chain head while the service is considered ready.
+	ReadyMaxLag uint64
+	// Confirmations is a number of confirmations,
+	// after which a transfer is final.
+	Confirmations uint64
+	// ConfirmationTiers override Confirmations for large transfers.
+	ConfirmationTiers []ConfirmationTier
+	// TrackConfirmations is a number of confirmations, up to which
+	// confirmations of transfers are updated. It is raised to the largest
+	// number of required confirmations.
+	TrackConfirmations uint64
+	// LeaderElection makes replicas sharing the database elect a leader,
+	// only the leader runs the scheduler tasks.
+	LeaderElection bool
+	// LeaderLease is a time after the last renewal of the lease,
+	// during which the leader is not replaced.
+	LeaderLease uint64 // In milliseconds.
+	// LeaderRenewPause is a pause between renewals of the lease by the
+	// leader and between attempts to take it by the other replicas.
+	LeaderRenewPause uint64 // In milliseconds.
+}
+
+// ConfirmationTier is a number of confirmations required for transfers
+// of an asset starting from an amount. If several tiers match a transfer,
+// the tier with the largest amount is used.
+type ConfirmationTier struct {
+	// Token is an ERC-20 token contract address. Empty value means ETH.
+	Token string
+	// MinAmount is in Wei or in the smallest token units.
+	MinAmount     *big.Int
+	Confirmations uint64
+}
+
+// Webhook is a configuration of webhook notifications.
+type Webhook struct {
+	// URLs is a list of endpoints, every event is delivered to each of
+	// them. Empty list disables webhooks.
+	URLs []string
+	// Secret is a key of HMAC-SHA256 signatures of the events.
+	// It is required if URLs are set.
+	Secret string
+	// Confirmations is a list of confirmation thresholds
+	// of deposits, which are notified.
+	Confirmations []uint64
+	DeliverPause  uint64 // In milliseconds.
+	// Timeout is a timeout of a delivery request.
+	Timeout uint64 // In milliseconds.
+	// MaxAttempts is a number of failed deliveries,
+	// after which an event is marked as dead.
+	MaxAttempts uint64
+	// MinBackoff is a delay before the first retry,
+	// it doubles with every retry.
+	MinBackoff uint64 // In seconds.
+	// MaxBackoff is a maximum delay before a retry.
+	MaxBackoff uint64 // In seconds.
+}
+
+// Sweep is a configuration of sweeping the excess funds of node
+// accounts to a cold address.
+type Sweep struct {
+	// ColdAddress is a recipient of the funds. It must not be a contract.
+	// Empty value disables sweeping.
+	ColdAddress string
+	// MinBalance is a minimal amount in Wei, which is worth sweeping.
+	MinBalance *big.Int
+	// Reserve is an amount in Wei left on an account.
+	Reserve *big.Int
+	// MaxFeePerGas is a fee cap of sweep transactions in Wei.
+	MaxFeePerGas *big.Int
+	Pause        uint64 // In milliseconds.
 }
 
 // NewConfig creates a default application configuration.
@@ -44,7 +211,18 @@ func NewConfig() *Config {
 			Addr: "localhost:80",
 		},
 		Eth: &Eth{
-			StartBlock: 0,
+			StartBlock:           0,
+			HealthCheckPause:     10000,
+			MaxHeadAge:           120,
+			FeeHistoryBlocks:     10,
+			FeeHistoryPercentile: 50,
+			GasMultiplier:        1.2,
+			GasLimitCap:          500000,
+			FeeBumpPercent:       15,
+			XPubPath:             "m/44'/60'/0'",
+			RetryAttempts:        5,
+			RetryMinBackoff:      200,
+			RetryMaxBackoff:      10000,
 		},
 		DB: &DB{
 			DBName: "unionbase",
@@ -57,6 +235,25 @@ func NewConfig() *Config {
 			CollectPause:            15000,
 			UpdateTransactionsPause: 20000,
 			SyncPause:               30000,
+			UpdateOutputsPause:      20000,
+			DropOutputAfter:         600,
+			MaxReorgDepth:           64,
+			ReadyMaxLag:             10,
+			Confirmations:           3,
+			TrackConfirmations:      6,
+			LeaderLease:             15000,
+			LeaderRenewPause:        5000,
+		},
+		Webhook: &Webhook{
+			Confirmations: []uint64{3, 6},
+			DeliverPause:  5000,
+			Timeout:       10000,
+			MaxAttempts:   10,
+			MinBackoff:    10,
+			MaxBackoff:    3600,
+		},
+		Sweep: &Sweep{
+			Pause: 600000,
 		},
 	}
 }
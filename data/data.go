@@ -8,12 +8,62 @@ const (
 	TxSuccessful = "successful"
 )
 
+// Output statuses.
+const (
+	OutputPending    = "pending"
+	OutputSuccessful = "successful"
+	OutputFailed     = "failed"
+	// OutputReplaced is a status of an output, which has been replaced
+	// by a mined transaction with the same nonce.
+	OutputReplaced = "replaced"
+	// OutputDropped is a status of an output, which has disappeared
+	// from the mempool without being mined.
+	OutputDropped = "dropped"
+)
+
+// Output kinds.
+const (
+	// OutputWithdrawal is a transfer requested through the API.
+	OutputWithdrawal = "withdrawal"
+	// OutputSweep is a transfer of the excess funds of an account
+	// to the cold address.
+	OutputSweep = "sweep"
+)
+
+// Webhook event statuses.
+const (
+	EventPending   = "pending"
+	EventDelivered = "delivered"
+	// EventDead is a status of an event, which has not been delivered
+	// within the maximum number of attempts.
+	EventDead = "dead"
+)
+
+// Account kinds.
+const (
+	// AccountNode is an account with the key on Geth node
+	// or in the keystore.
+	AccountNode = "node"
+	// AccountDerived is a deposit address derived
+	// from the extended public key.
+	AccountDerived = "derived"
+	// AccountWatch is a watch-only address without a key.
+	AccountWatch = "watch"
+)
+
 // Account is an Ethereum account.
 //reform:accounts
 type Account struct {
 	ID        string `json:"id" reform:"id,pk"`
 	Balance   string `json:"balance" reform:"balance"`
 	PublicKey string `json:"publicKey" reform:"public_key"`
+	// DerivationPath is a BIP-32 path of an address derived from
+	// the extended public key. It is nil for node accounts.
+	DerivationPath *string `json:"derivationPath" reform:"derivation_path"`
+	// Kind is node, derived or watch.
+	Kind string `json:"kind" reform:"kind"`
+	// Label is a description of a watch-only address.
+	Label *string `json:"label" reform:"label"`
 }
 
 // Transaction is an Ethereum transaction.
@@ -29,6 +79,43 @@ type Transaction struct {
 	Timestamp     *uint64 `json:"timestamp" reform:"timestamp"`
 	Marked        bool    `json:"marked" reform:"marked"`
 	Confirmations uint64  `json:"confirmations" reform:"confirmations"`
+	// TracePath is a path of an internal call in the call tree of
+	// the transaction, e.g. "0.2" is the third call made by the first
+	// call of the transaction. It is nil for top-level transactions.
+	TracePath *string `json:"tracePath" reform:"trace_path"`
+	// Gas and fee fields are nil for internal calls,
+	// the fee is paid by the top-level transaction.
+	GasLimit *uint64 `json:"gasLimit" reform:"gas_limit"`
+	GasUsed  *uint64 `json:"gasUsed" reform:"gas_used"`
+	// EffectiveGasPrice is a price paid per gas in Wei.
+	EffectiveGasPrice *string `json:"effectiveGasPrice" reform:"effective_gas_price"`
+	// Fee is a total fee in Wei, it is the sum of BurnedFee and TipFee.
+	Fee *string `json:"fee" reform:"fee"`
+	// BurnedFee is a base fee part of the fee in Wei. It is nil
+	// for blocks before EIP-1559.
+	BurnedFee *string `json:"burnedFee" reform:"burned_fee"`
+	// TipFee is a priority fee part of the fee in Wei, which is paid
+	// to the miner. It is nil for blocks before EIP-1559.
+	TipFee *string `json:"tipFee" reform:"tip_fee"`
+	// TxType is an EIP-2718 transaction type: 0 for legacy,
+	// 1 for access list and 2 for dynamic fee transactions.
+	TxType *uint64 `json:"txType" reform:"tx_type"`
+}
+
+// TokenTransfer is an ERC-20 token transfer.
+//reform:token_transfers
+type TokenTransfer struct {
+	ID            string  `json:"id" reform:"id,pk"`
+	Hash          string  `json:"hash" reform:"hash"`
+	Token         string  `json:"token" reform:"token"`
+	LogIndex      uint64  `json:"logIndex" reform:"log_index"`
+	From          string  `json:"from" reform:"from"`
+	To            string  `json:"to" reform:"to"`
+	Amount        string  `json:"amount" reform:"amount"`
+	Block         *uint64 `json:"block" reform:"block"`
+	Timestamp     *uint64 `json:"timestamp" reform:"timestamp"`
+	Marked        bool    `json:"marked" reform:"marked"`
+	Confirmations uint64  `json:"confirmations" reform:"confirmations"`
 }
 
 // Output is an outgoing transaction.
@@ -37,6 +124,60 @@ type Output struct {
 	ID      string `json:"id" reform:"id,pk"`
 	Hash    string `json:"hash" reform:"hash"`
 	Account string `json:"account" reform:"account"`
+	// SentAt is a Unix time when the transaction was sent.
+	SentAt *uint64 `json:"sentAt" reform:"sent_at"`
+	// ReplacedBy is an ID of the output, which replaces this one
+	// with the same nonce.
+	ReplacedBy *string `json:"replacedBy" reform:"replaced_by"`
+	// MinedHash is a hash of the transaction mined with the nonce
+	// of this output. It is the same for an output and its replacements.
+	MinedHash *string `json:"minedHash" reform:"mined_hash"`
+	Status    string  `json:"status" reform:"status"`
+	Block     *uint64 `json:"block" reform:"block"`
+	BlockHash *string `json:"blockHash" reform:"block_hash"`
+	GasUsed   *uint64 `json:"gasUsed" reform:"gas_used"`
+	// EffectiveGasPrice is a price paid per gas in Wei.
+	EffectiveGasPrice *string `json:"effectiveGasPrice" reform:"effective_gas_price"`
+	Confirmations     uint64  `json:"confirmations" reform:"confirmations"`
+	// MinedAt is a Unix time of the block with the transaction.
+	MinedAt *uint64 `json:"minedAt" reform:"mined_at"`
+	// LastSeenAt is a Unix time when the transaction
+	// was last seen in the mempool.
+	LastSeenAt *uint64 `json:"lastSeenAt" reform:"last_seen_at"`
+	// Kind is withdrawal or sweep.
+	Kind string `json:"kind" reform:"kind"`
+	// Amount is a transferred amount in Wei.
+	Amount *string `json:"amount" reform:"amount"`
+	// Nonce is a nonce of the transaction. It is nil until
+	// the transaction is seen on the node.
+	Nonce *uint64 `json:"nonce" reform:"nonce"`
+}
+
+// Balance is a balance of an account at a block.
+//reform:balances
+type Balance struct {
+	ID      string `json:"id" reform:"id,pk"`
+	Account string `json:"account" reform:"account"`
+	Block   uint64 `json:"block" reform:"block"`
+	// Timestamp is a Unix time of the block.
+	Timestamp uint64 `json:"timestamp" reform:"timestamp"`
+	// Balance is in Wei.
+	Balance string `json:"balance" reform:"balance"`
+}
+
+// Block is a processed Ethereum block.
+//reform:blocks
+type Block struct {
+	Number     uint64 `json:"number" reform:"number,pk"`
+	Hash       string `json:"hash" reform:"hash"`
+	ParentHash string `json:"parentHash" reform:"parent_hash"`
+}
+
+// Nonce is the next nonce of outgoing transactions of an account.
+//reform:nonces
+type Nonce struct {
+	Account string `json:"account" reform:"account,pk"`
+	Next    uint64 `json:"next" reform:"next"`
 }
 
 // Setting is an application setting.
@@ -45,3 +186,24 @@ type Setting struct {
 	Key   string `json:"key" reform:"key,pk"`
 	Value string `json:"value" reform:"value"`
 }
+
+// WebhookEvent is a webhook notification queued for delivery
+// to an endpoint.
+//reform:webhook_events
+type WebhookEvent struct {
+	ID   string `json:"id" reform:"id,pk"`
+	Type string `json:"type" reform:"type"`
+	// Key identifies the event, so that it is queued only once
+	// for an endpoint, e.g. "deposit.confirmed:<transaction id>:6".
+	Key     string `json:"key" reform:"key"`
+	URL     string `json:"url" reform:"url"`
+	Payload string `json:"payload" reform:"payload"`
+	Status  string `json:"status" reform:"status"`
+	// Attempts is a number of failed deliveries.
+	Attempts uint64 `json:"attempts" reform:"attempts"`
+	// NextAttemptAt is a Unix time of the next delivery.
+	NextAttemptAt uint64  `json:"nextAttemptAt" reform:"next_attempt_at"`
+	LastError     *string `json:"lastError" reform:"last_error"`
+	CreatedAt     uint64  `json:"createdAt" reform:"created_at"`
+	DeliveredAt   *uint64 `json:"deliveredAt" reform:"delivered_at"`
+}
@@ -2,17 +2,34 @@ BEGIN TRANSACTION;
 
 DROP TABLE IF EXISTS accounts;
 DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS token_transfers;
 DROP TABLE IF EXISTS settings;
 DROP TABLE IF EXISTS outputs;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS nonces;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS balances;
 
 DROP TYPE IF EXISTS tx_status;
+DROP TYPE IF EXISTS account_kind;
+DROP TYPE IF EXISTS output_status;
+DROP TYPE IF EXISTS event_status;
+DROP TYPE IF EXISTS output_kind;
 
 CREATE TYPE tx_status AS ENUM ('failed','successful');
+CREATE TYPE account_kind AS ENUM ('node','derived','watch');
+CREATE TYPE output_status AS ENUM ('pending','successful','failed',
+  'replaced','dropped');
+CREATE TYPE event_status AS ENUM ('pending','delivered','dead');
+CREATE TYPE output_kind AS ENUM ('withdrawal','sweep');
 
 CREATE TABLE accounts (
   id text PRIMARY KEY,
   balance text NOT NULL,
-  public_key text NOT NULL
+  public_key text NOT NULL,
+  derivation_path text,
+  kind account_kind NOT NULL DEFAULT 'node',
+  label text
 );
 
 CREATE INDEX IF NOT EXISTS account_public_key ON accounts(public_key);
@@ -27,22 +44,109 @@ CREATE TABLE transactions (
   block bigint,
   timestamp  bigint,
   marked  bool,
-  confirmations bigint NOT NULL
+  confirmations bigint NOT NULL,
+  trace_path text,
+  gas_limit bigint,
+  gas_used bigint,
+  effective_gas_price text,
+  fee text,
+  burned_fee text,
+  tip_fee text,
+  tx_type smallint
 );
 
+CREATE TABLE token_transfers (
+  id text PRIMARY KEY,
+  hash text NOT NULL,
+  token text NOT NULL,
+  log_index bigint NOT NULL,
+  "from" text NOT NULL,
+  "to" text NOT NULL,
+  amount text NOT NULL,
+  block bigint,
+  timestamp  bigint,
+  marked  bool,
+  confirmations bigint NOT NULL,
+  UNIQUE (hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS token_transfer_to ON token_transfers ("to");
+CREATE INDEX IF NOT EXISTS token_transfer_block ON token_transfers(block);
+
 CREATE TABLE outputs (
   id text PRIMARY KEY,
   hash text NOT NULL,
-  account text NOT NULL
+  account text NOT NULL,
+  sent_at bigint,
+  replaced_by text REFERENCES outputs(id),
+  mined_hash text,
+  status output_status NOT NULL DEFAULT 'pending',
+  block bigint,
+  block_hash text,
+  gas_used bigint,
+  effective_gas_price text,
+  confirmations bigint NOT NULL DEFAULT 0,
+  mined_at bigint,
+  last_seen_at bigint,
+  kind output_kind NOT NULL DEFAULT 'withdrawal',
+  amount text,
+  nonce bigint
 );
 
+CREATE INDEX IF NOT EXISTS output_hash ON outputs(hash);
+CREATE INDEX IF NOT EXISTS output_replaced_by ON outputs(replaced_by);
+CREATE INDEX IF NOT EXISTS output_status_confirmations
+  ON outputs(status, confirmations);
+
 CREATE INDEX IF NOT EXISTS tx_hash ON transactions(hash);
 CREATE INDEX IF NOT EXISTS tx_from ON transactions ("from");
 CREATE INDEX IF NOT EXISTS tx_to ON transactions ("to");
+CREATE INDEX IF NOT EXISTS tx_block ON transactions(block);
+
+CREATE TABLE balances (
+  id text PRIMARY KEY,
+  account text NOT NULL,
+  block bigint NOT NULL,
+  timestamp bigint NOT NULL,
+  balance text NOT NULL,
+  UNIQUE (account, block)
+);
+
+CREATE INDEX IF NOT EXISTS balance_account_timestamp
+  ON balances(account, timestamp);
+
+CREATE TABLE blocks (
+  number bigint PRIMARY KEY,
+  hash text NOT NULL,
+  parent_hash text NOT NULL
+);
+
+CREATE TABLE nonces (
+  account text PRIMARY KEY,
+  next bigint NOT NULL
+);
 
 CREATE TABLE settings (
   key text PRIMARY KEY,
   value text NOT NULL
 );
 
+CREATE TABLE webhook_events (
+  id text PRIMARY KEY,
+  type text NOT NULL,
+  key text NOT NULL,
+  url text NOT NULL,
+  payload text NOT NULL,
+  status event_status NOT NULL DEFAULT 'pending',
+  attempts bigint NOT NULL DEFAULT 0,
+  next_attempt_at bigint NOT NULL,
+  last_error text,
+  created_at bigint NOT NULL,
+  delivered_at bigint,
+  UNIQUE (key, url)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_event_status_next_attempt
+  ON webhook_events(status, next_attempt_at);
+
 END TRANSACTION;
\ No newline at end of file
@@ -5,44 +5,80 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/core/types"
 	"math/big"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/dzeckelev/geth-wrapper/config"
 )
 
 // Client describes Ethereum client interface.
 type Client interface {
 	Accounts(ctx context.Context) ([]string, error)
-	SendTransaction(ctx context.Context,
-		from, to common.Address, amount *big.Int) (*string, error)
+	NewAccount(ctx context.Context, password string) (common.Address, error)
+	UnlockAccount(ctx context.Context, account common.Address,
+		password string, duration *uint64) error
+	LockAccount(ctx context.Context, account common.Address) error
+	SendTransaction(ctx context.Context, from, to common.Address,
+		amount *big.Int, caps *FeeCaps) (*string, error)
+	ReplaceTransaction(ctx context.Context, from common.Address,
+		hash common.Hash, cancel bool, caps *FeeCaps) (*string, error)
 	NetworkID(ctx context.Context) (*big.Int, error)
 	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (
+		tx *types.Transaction, isPending bool, err error)
 	TransactionReceipt(ctx context.Context,
 		txHash common.Hash) (*types.Receipt, error)
+	TransactionReceipts(ctx context.Context,
+		hashes []common.Hash) ([]*types.Receipt, error)
+	BlockReceipts(ctx context.Context,
+		hash common.Hash) ([]*types.Receipt, error)
+	FilterLogs(ctx context.Context,
+		query ethereum.FilterQuery) ([]types.Log, error)
+	TraceBlock(ctx context.Context, number *big.Int) ([]*TxTrace, error)
 	BalanceAt(ctx context.Context, account common.Address,
 		blockNumber *big.Int) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address,
+		blockNumber *big.Int) (uint64, error)
 	SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error)
+	SubscribeNewHead(ctx context.Context,
+		ch chan<- *types.Header) (ethereum.Subscription, error)
 }
 
-// GethClient is an Ethereum JSON-RPC client.
+// GethClient is an Ethereum JSON-RPC client. Transactions from accounts
+// of the signer are signed locally, transactions from other accounts
+// are signed by Geth node.
 type GethClient struct {
+	cfg    *config.Eth
 	rpcCli *rpc.Client
 	ethCli *ethclient.Client
+	signer Signer
+	nonces *NonceManager
+
+	// noBlockReceipts is set if the node
+	// does not support eth_getBlockReceipts.
+	noBlockReceipts int32
 }
 
 // SendTxArgs is an arguments to send transaction.
 type SendTxArgs struct {
-	From     string `json:"from"`
-	To       string `json:"to"`
-	Gas      string `json:"gas"`
-	GasPrice string `json:"gasPrice"`
-	Value    string `json:"value"`
+	From                 string `json:"from"`
+	To                   string `json:"to"`
+	Gas                  string `json:"gas"`
+	Nonce                string `json:"nonce,omitempty"`
+	GasPrice             string `json:"gasPrice,omitempty"`
+	MaxFeePerGas         string `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"`
+	Value                string `json:"value"`
 }
 
 // NewClient creates a new  Ethereum JSON-RPC client.
-func NewClient(ctx context.Context, url string) (*GethClient, error) {
+// The signer is optional.
+func NewClient(ctx context.Context, url string, cfg *config.Eth,
+	signer Signer) (*GethClient, error) {
 	rpcClient, err := rpc.DialContext(ctx, url)
 	if err != nil {
 		return nil, err
@@ -51,40 +87,109 @@ func NewClient(ctx context.Context, url string) (*GethClient, error) {
 	ethCli := ethclient.NewClient(rpcClient)
 
 	return &GethClient{
+		cfg:    cfg,
 		rpcCli: rpcClient,
 		ethCli: ethCli,
+		signer: signer,
 	}, nil
 }
 
+// SetNonceManager sets a nonce manager, which assigns nonces
+// to outgoing transactions. Without it nonces are assigned by Geth node.
+func (c *GethClient) SetNonceManager(nonces *NonceManager) {
+	c.nonces = nonces
+}
+
 // Close closes an Ethereum JSON-RPC client.
 func (c *GethClient) Close() {
-	c.Close()
+	c.rpcCli.Close()
 }
 
-// Accounts gets accounts from Geth node.
+// Accounts gets accounts from Geth node and from the signer.
 func (c *GethClient) Accounts(ctx context.Context) ([]string, error) {
 	var result []string
 	err := c.rpcCli.CallContext(ctx, &result, "personal_listAccounts")
-	return result, err
+	if err != nil || c.signer == nil {
+		return result, err
+	}
+
+	exists := make(map[string]bool)
+	for k := range result {
+		result[k] = strings.ToLower(result[k])
+		exists[result[k]] = true
+	}
+
+	for _, acc := range c.signer.Accounts() {
+		addr := strings.ToLower(acc.Hex())
+		if !exists[addr] {
+			result = append(result, addr)
+		}
+	}
+
+	return result, nil
 }
 
 // SendTransaction sends a transaction through Geth node.
-// Account must be unlocked.
-func (c *GethClient) SendTransaction(ctx context.Context,
-	from, to common.Address, amount *big.Int) (result *string, err error) {
-	gas := uint64(34000)
+// On networks with EIP-1559 a dynamic-fee transaction is sent,
+// its fees are limited by caps. If the account is managed by the signer,
+// the transaction is signed locally, otherwise the account must be
+// unlocked on Geth node. Gas is estimated with eth_estimateGas,
+// estimation failures are returned as *GasEstimationError.
+func (c *GethClient) SendTransaction(ctx context.Context, from,
+	to common.Address, amount *big.Int,
+	caps *FeeCaps) (result *string, err error) {
+	fees, err := c.suggestFees(ctx, caps)
+	if err != nil {
+		return nil, err
+	}
 
-	gasPrice, err := c.ethCli.SuggestGasPrice(ctx)
+	gas, err := c.estimateGas(ctx, from, to, amount, fees)
 	if err != nil {
 		return nil, err
 	}
 
+	send := func(nonce *uint64) (*string, error) {
+		if c.signer != nil && c.signer.Contains(from) {
+			return c.sendRawTransaction(ctx, from, to, amount, gas,
+				fees, nonce)
+		}
+		return c.sendGethTransaction(ctx, from, to, amount, gas,
+			fees, nonce)
+	}
+
+	if c.nonces == nil {
+		return send(nil)
+	}
+
+	err = c.nonces.Send(ctx, from, func(nonce uint64) (err error) {
+		result, err = send(&nonce)
+		return err
+	})
+	return result, err
+}
+
+// sendGethTransaction sends a transaction with eth_sendTransaction,
+// the transaction is signed by Geth node. If nonce is nil,
+// it is assigned by Geth node.
+func (c *GethClient) sendGethTransaction(ctx context.Context,
+	from, to common.Address, amount *big.Int, gas uint64,
+	fees *Fees, nonce *uint64) (result *string, err error) {
 	args := SendTxArgs{
-		From:     from.Hex(),
-		To:       to.Hex(),
-		Gas:      hexutil.EncodeUint64(gas),
-		GasPrice: hexutil.EncodeBig(gasPrice),
-		Value:    hexutil.EncodeBig(amount),
+		From:  from.Hex(),
+		To:    to.Hex(),
+		Gas:   hexutil.EncodeUint64(gas),
+		Value: hexutil.EncodeBig(amount),
+	}
+
+	if nonce != nil {
+		args.Nonce = hexutil.EncodeUint64(*nonce)
+	}
+
+	if fees.IsDynamic() {
+		args.MaxFeePerGas = hexutil.EncodeBig(fees.GasFeeCap)
+		args.MaxPriorityFeePerGas = hexutil.EncodeBig(fees.GasTipCap)
+	} else {
+		args.GasPrice = hexutil.EncodeBig(fees.GasPrice)
 	}
 
 	err = c.rpcCli.CallContext(ctx, &result,
@@ -92,6 +197,66 @@ func (c *GethClient) SendTransaction(ctx context.Context,
 	return result, err
 }
 
+// sendRawTransaction signs a transaction with the signer and sends it
+// with eth_sendRawTransaction. If nonce is nil, the pending nonce
+// of the account is used.
+func (c *GethClient) sendRawTransaction(ctx context.Context,
+	from, to common.Address, amount *big.Int, gas uint64,
+	fees *Fees, nonce *uint64) (*string, error) {
+	if nonce == nil {
+		pending, err := c.ethCli.PendingNonceAt(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+		nonce = &pending
+	}
+
+	chainID, err := c.ethCli.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var txData types.TxData
+	if fees.IsDynamic() {
+		txData = &types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     *nonce,
+			GasTipCap: fees.GasTipCap,
+			GasFeeCap: fees.GasFeeCap,
+			Gas:       gas,
+			To:        &to,
+			Value:     amount,
+		}
+	} else {
+		txData = &types.LegacyTx{
+			Nonce:    *nonce,
+			GasPrice: fees.GasPrice,
+			Gas:      gas,
+			To:       &to,
+			Value:    amount,
+		}
+	}
+
+	tx, err := c.signer.SignTx(from, types.NewTx(txData), chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.ethCli.SendTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	hash := tx.Hash().String()
+	return &hash, nil
+}
+
+// PendingNonceAt returns the next nonce of the account,
+// including transactions in the mempool.
+func (c *GethClient) PendingNonceAt(ctx context.Context,
+	account common.Address) (uint64, error) {
+	return c.ethCli.PendingNonceAt(ctx, account)
+}
+
 func (c *GethClient) NetworkID(ctx context.Context) (*big.Int, error) {
 	return c.ethCli.NetworkID(ctx)
 }
@@ -101,6 +266,11 @@ func (c *GethClient) BlockByNumber(ctx context.Context,
 	return c.ethCli.BlockByNumber(ctx, number)
 }
 
+func (c *GethClient) TransactionByHash(ctx context.Context,
+	hash common.Hash) (*types.Transaction, bool, error) {
+	return c.ethCli.TransactionByHash(ctx, hash)
+}
+
 func (c *GethClient) TransactionReceipt(ctx context.Context,
 	txHash common.Hash) (*types.Receipt, error) {
 	return c.ethCli.TransactionReceipt(ctx, txHash)
@@ -111,7 +281,22 @@ func (c *GethClient) BalanceAt(ctx context.Context, account common.Address,
 	return c.ethCli.BalanceAt(ctx, account, blockNumber)
 }
 
+// NonceAt returns the nonce of the account at the block,
+// transactions in the mempool are not counted.
+func (c *GethClient) NonceAt(ctx context.Context, account common.Address,
+	blockNumber *big.Int) (uint64, error) {
+	return c.ethCli.NonceAt(ctx, account, blockNumber)
+}
+
 func (c *GethClient) SyncProgress(
 	ctx context.Context) (*ethereum.SyncProgress, error) {
 	return c.ethCli.SyncProgress(ctx)
 }
+
+// SubscribeNewHead subscribes to new block headers. Subscriptions are
+// supported only by WebSocket and IPC connections, for HTTP connections
+// rpc.ErrNotificationsUnsupported is returned.
+func (c *GethClient) SubscribeNewHead(ctx context.Context,
+	ch chan<- *types.Header) (ethereum.Subscription, error) {
+	return c.ethCli.SubscribeNewHead(ctx, ch)
+}
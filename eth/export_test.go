@@ -0,0 +1,36 @@
+package eth
+
+// DynamicFees exports dynamicFees for tests.
+var DynamicFees = dynamicFees
+
+// BumpFee exports bumpFee for tests.
+var BumpFee = bumpFee
+
+// SuggestFees exports suggestFees for tests.
+var SuggestFees = (*GethClient).suggestFees
+
+// ApplyGasLimits exports applyGasLimits for tests.
+var ApplyGasLimits = applyGasLimits
+
+// NewGasEstimationError exports newGasEstimationError for tests.
+var NewGasEstimationError = newGasEstimationError
+
+// CheckNodes exports checkNodes for tests.
+var CheckNodes = (*FailoverClient).checkNodes
+
+// CurrentNode returns the URL of the current node.
+func CurrentNode(c *FailoverClient) string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.current == nil {
+		return ""
+	}
+	return c.current.url
+}
+
+// CapFee exports capFee for tests.
+var CapFee = capFee
+
+// ReplacementFees exports replacementFees for tests.
+var ReplacementFees = replacementFees
@@ -0,0 +1,694 @@
+package eth
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+)
+
+// ErrNoHealthyNode is returned when none of the nodes can serve calls.
+var ErrNoHealthyNode = errors.New("no healthy Ethereum node")
+
+// headTolerance is a number of blocks a node can be behind
+// the current node before it is replaced.
+const headTolerance = 1
+
+type node struct {
+	url    string
+	client *GethClient
+
+	// available is true if the node is reachable
+	// and is on the expected network.
+	available bool
+	synced    bool
+	fresh     bool
+	head      uint64
+	// accounts are accounts with keys held by the node.
+	accounts map[common.Address]struct{}
+}
+
+// rank returns the priority of a node: synced nodes with a fresh head
+// are preferred, syncing or stale nodes are used only if there are
+// no better ones.
+func (n *node) rank() int {
+	switch {
+	case n == nil || !n.available:
+		return 0
+	case n.synced && n.fresh:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (n *node) betterThan(other *node) bool {
+	if n.rank() != other.rank() {
+		return n.rank() > other.rank()
+	}
+	return other != nil && n.head > other.head+headTolerance
+}
+
+// FailoverClient is an Ethereum client, which health-checks several nodes
+// and routes calls to the best one. Nodes on a different network
+// are never used.
+type FailoverClient struct {
+	cfg    *config.Eth
+	signer Signer
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx     sync.RWMutex
+	nodes   []*node
+	current *node
+	chainID *big.Int
+	nonces  *NonceManager
+	// switched is closed and replaced when the current node changes.
+	switched chan struct{}
+
+	wg sync.WaitGroup
+}
+
+// NewFailoverClient creates a new failover client for the nodes from
+// configuration. The signer is optional.
+func NewFailoverClient(ctx context.Context, cfg *config.Eth,
+	signer Signer) (*FailoverClient, error) {
+	if len(cfg.NodeURL) == 0 {
+		return nil, errors.New("no Ethereum nodes configured")
+	}
+
+	// Otherwise the first available node, which can be misconfigured,
+	// decides the network.
+	if len(cfg.NodeURL) > 1 && cfg.ChainID == 0 {
+		return nil, errors.New("Eth.ChainID is required for several nodes")
+	}
+
+	if cfg.HealthCheckPause == 0 {
+		return nil, errors.New("Eth.HealthCheckPause must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := &FailoverClient{
+		cfg:      cfg,
+		signer:   signer,
+		ctx:      ctx,
+		cancel:   cancel,
+		switched: make(chan struct{}),
+	}
+
+	if cfg.ChainID != 0 {
+		c.chainID = new(big.Int).SetUint64(cfg.ChainID)
+	}
+
+	for _, u := range cfg.NodeURL {
+		c.nodes = append(c.nodes, &node{url: u})
+	}
+
+	c.checkNodes()
+
+	if c.current.rank() == 0 {
+		c.Close()
+		return nil, ErrNoHealthyNode
+	}
+
+	c.wg.Add(1)
+	go c.healthCheck()
+
+	return c, nil
+}
+
+// Close stops health checks and closes connections to the nodes.
+func (c *FailoverClient) Close() {
+	c.cancel()
+	c.wg.Wait()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, n := range c.nodes {
+		if n.client != nil {
+			n.client.Close()
+		}
+	}
+}
+
+// SetNonceManager sets a nonce manager for all nodes.
+func (c *FailoverClient) SetNonceManager(nonces *NonceManager) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.nonces = nonces
+	for _, n := range c.nodes {
+		if n.client != nil {
+			n.client.SetNonceManager(nonces)
+		}
+	}
+}
+
+func (c *FailoverClient) healthCheck() {
+	defer c.wg.Done()
+
+	tic := time.NewTicker(time.Millisecond *
+		time.Duration(c.cfg.HealthCheckPause))
+	for {
+		select {
+		case <-tic.C:
+			c.checkNodes()
+		case <-c.ctx.Done():
+			tic.Stop()
+			return
+		}
+	}
+}
+
+// checkNodes checks all nodes and selects the best one.
+func (c *FailoverClient) checkNodes() {
+	for _, n := range c.nodes {
+		if err := c.checkNode(n); err != nil {
+			c.mtx.Lock()
+			n.available = false
+			c.mtx.Unlock()
+
+			log.Printf("Ethereum node %s is unavailable: %s",
+				redactURL(n.url), err)
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	best := c.current
+	for _, n := range c.nodes {
+		if n.betterThan(best) {
+			best = n
+		}
+	}
+
+	if best == c.current {
+		return
+	}
+
+	if c.current == nil {
+		log.Printf("using Ethereum node %s", redactURL(best.url))
+	} else {
+		log.Printf("switched Ethereum node from %s to %s",
+			redactURL(c.current.url), redactURL(best.url))
+	}
+
+	c.current = best
+	close(c.switched)
+	c.switched = make(chan struct{})
+}
+
+func (c *FailoverClient) checkNode(n *node) error {
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*
+		time.Duration(c.cfg.HealthCheckPause))
+	defer cancel()
+
+	c.mtx.RLock()
+	client := n.client
+	c.mtx.RUnlock()
+
+	if client == nil {
+		var err error
+		client, err = NewClient(ctx, n.url, c.cfg, c.signer)
+		if err != nil {
+			return err
+		}
+
+		c.mtx.Lock()
+		n.client = client
+		if c.nonces != nil {
+			client.SetNonceManager(c.nonces)
+		}
+		c.mtx.Unlock()
+	}
+
+	chainID, err := client.ethCli.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+
+	progress, err := client.ethCli.SyncProgress(ctx)
+	if err != nil {
+		return err
+	}
+
+	head, err := client.ethCli.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Hosted providers do not support the personal API,
+	// such nodes hold no keys.
+	accounts, accErr := client.nodeAccounts(ctx)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.chainID == nil {
+		c.chainID = chainID
+	}
+
+	if c.chainID.Cmp(chainID) != 0 {
+		return errors.Errorf("node is on a different network:"+
+			" chain id %s, expected %s", chainID, c.chainID)
+	}
+
+	age := time.Since(time.Unix(int64(head.Time), 0))
+
+	n.available = true
+	n.synced = progress == nil
+	n.fresh = age < time.Duration(c.cfg.MaxHeadAge)*time.Second
+	n.head = head.Number.Uint64()
+
+	if accErr == nil {
+		n.accounts = make(map[common.Address]struct{}, len(accounts))
+		for _, acc := range accounts {
+			n.accounts[acc] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (c *FailoverClient) client() (*GethClient, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.current.rank() == 0 {
+		return nil, ErrNoHealthyNode
+	}
+
+	return c.current.client, nil
+}
+
+// clientFor returns the client of a node, which can send transactions
+// of the account: the current node, if the account is managed by the
+// signer or the current node holds its key, otherwise the best node
+// holding the key.
+func (c *FailoverClient) clientFor(account common.Address) (*GethClient,
+	error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.current.rank() == 0 {
+		return nil, ErrNoHealthyNode
+	}
+
+	if c.signer != nil && c.signer.Contains(account) {
+		return c.current.client, nil
+	}
+
+	if _, ok := c.current.accounts[account]; ok {
+		return c.current.client, nil
+	}
+
+	var best *node
+	for _, n := range c.holders(account) {
+		if best == nil || n.betterThan(best) {
+			best = n
+		}
+	}
+
+	if best == nil {
+		return c.current.client, nil
+	}
+	return best.client, nil
+}
+
+// holders returns available nodes holding the key of the account,
+// mtx must be locked.
+func (c *FailoverClient) holders(account common.Address) (result []*node) {
+	for _, n := range c.nodes {
+		if _, ok := n.accounts[account]; ok && n.rank() != 0 {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// redactURL removes credentials, path and query from a node URL,
+// because hosted providers keep API keys there.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Accounts gets accounts from all available nodes and from the signer,
+// so that the accounts stay managed after a node switch.
+func (c *FailoverClient) Accounts(ctx context.Context) ([]string, error) {
+	c.mtx.RLock()
+	var clients []*GethClient
+	for _, n := range c.nodes {
+		if n.rank() != 0 {
+			clients = append(clients, n.client)
+		}
+	}
+	c.mtx.RUnlock()
+
+	if len(clients) == 0 {
+		return nil, ErrNoHealthyNode
+	}
+
+	var result []string
+	var lastErr error
+	succeeded := false
+	exists := make(map[string]bool)
+
+	for _, client := range clients {
+		accounts, err := client.Accounts(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		succeeded = true
+
+		for _, acc := range accounts {
+			acc = strings.ToLower(acc)
+			if !exists[acc] {
+				exists[acc] = true
+				result = append(result, acc)
+			}
+		}
+	}
+
+	if !succeeded {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+// NewAccount creates a new account on the current node. The key is
+// stored only on this node, transactions of the account are sent
+// through it.
+func (c *FailoverClient) NewAccount(ctx context.Context,
+	password string) (common.Address, error) {
+	c.mtx.RLock()
+	current := c.current
+	c.mtx.RUnlock()
+
+	if current.rank() == 0 {
+		return common.Address{}, ErrNoHealthyNode
+	}
+
+	account, err := current.client.NewAccount(ctx, password)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	c.mtx.Lock()
+	if current.accounts == nil {
+		current.accounts = make(map[common.Address]struct{})
+	}
+	current.accounts[account] = struct{}{}
+	c.mtx.Unlock()
+
+	return account, nil
+}
+
+// UnlockAccount unlocks an account on all available nodes holding
+// its key.
+func (c *FailoverClient) UnlockAccount(ctx context.Context,
+	account common.Address, password string, duration *uint64) error {
+	return c.onHolders(account, func(client *GethClient) error {
+		return client.UnlockAccount(ctx, account, password, duration)
+	})
+}
+
+// LockAccount locks an account on all available nodes holding its key.
+func (c *FailoverClient) LockAccount(ctx context.Context,
+	account common.Address) error {
+	return c.onHolders(account, func(client *GethClient) error {
+		return client.LockAccount(ctx, account)
+	})
+}
+
+// onHolders calls the function for every available node holding the key
+// of the account. If no node is known to hold it, the current node
+// is used.
+func (c *FailoverClient) onHolders(account common.Address,
+	fn func(client *GethClient) error) error {
+	c.mtx.RLock()
+	var clients []*GethClient
+	for _, n := range c.holders(account) {
+		clients = append(clients, n.client)
+	}
+	c.mtx.RUnlock()
+
+	if len(clients) == 0 {
+		client, err := c.client()
+		if err != nil {
+			return err
+		}
+		clients = append(clients, client)
+	}
+
+	for _, client := range clients {
+		if err := fn(client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendTransaction sends a transaction through the current node or,
+// if the key of the sender is held by another node, through that node.
+func (c *FailoverClient) SendTransaction(ctx context.Context, from,
+	to common.Address, amount *big.Int, caps *FeeCaps) (*string, error) {
+	client, err := c.clientFor(from)
+	if err != nil {
+		return nil, err
+	}
+	return client.SendTransaction(ctx, from, to, amount, caps)
+}
+
+// ReplaceTransaction replaces a pending transaction through the node
+// selected as for SendTransaction.
+func (c *FailoverClient) ReplaceTransaction(ctx context.Context,
+	from common.Address, hash common.Hash, cancel bool,
+	caps *FeeCaps) (*string, error) {
+	client, err := c.clientFor(from)
+	if err != nil {
+		return nil, err
+	}
+	return client.ReplaceTransaction(ctx, from, hash, cancel, caps)
+}
+
+// PendingNonceAt returns the next nonce of the account
+// from the current node.
+func (c *FailoverClient) PendingNonceAt(ctx context.Context,
+	account common.Address) (uint64, error) {
+	client, err := c.client()
+	if err != nil {
+		return 0, err
+	}
+	return client.PendingNonceAt(ctx, account)
+}
+
+// NetworkID returns the network ID of the current node.
+func (c *FailoverClient) NetworkID(ctx context.Context) (*big.Int, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.NetworkID(ctx)
+}
+
+// BlockByNumber returns a block from the current node.
+func (c *FailoverClient) BlockByNumber(ctx context.Context,
+	number *big.Int) (*types.Block, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.BlockByNumber(ctx, number)
+}
+
+// TransactionByHash returns a transaction from the current node.
+func (c *FailoverClient) TransactionByHash(ctx context.Context,
+	hash common.Hash) (*types.Transaction, bool, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, false, err
+	}
+	return client.TransactionByHash(ctx, hash)
+}
+
+// TransactionReceipt returns a transaction receipt from the current node.
+func (c *FailoverClient) TransactionReceipt(ctx context.Context,
+	txHash common.Hash) (*types.Receipt, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.TransactionReceipt(ctx, txHash)
+}
+
+// TransactionReceipts returns transaction receipts from the current node.
+func (c *FailoverClient) TransactionReceipts(ctx context.Context,
+	hashes []common.Hash) ([]*types.Receipt, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.TransactionReceipts(ctx, hashes)
+}
+
+// BlockReceipts returns block receipts from the current node.
+func (c *FailoverClient) BlockReceipts(ctx context.Context,
+	hash common.Hash) ([]*types.Receipt, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.BlockReceipts(ctx, hash)
+}
+
+// FilterLogs returns logs from the current node.
+func (c *FailoverClient) FilterLogs(ctx context.Context,
+	query ethereum.FilterQuery) ([]types.Log, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.FilterLogs(ctx, query)
+}
+
+// TraceBlock traces a block on the current node.
+func (c *FailoverClient) TraceBlock(ctx context.Context,
+	number *big.Int) ([]*TxTrace, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.TraceBlock(ctx, number)
+}
+
+// BalanceAt returns an account balance from the current node.
+func (c *FailoverClient) BalanceAt(ctx context.Context,
+	account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.BalanceAt(ctx, account, blockNumber)
+}
+
+// NonceAt returns the nonce of an account from the current node.
+func (c *FailoverClient) NonceAt(ctx context.Context,
+	account common.Address, blockNumber *big.Int) (uint64, error) {
+	client, err := c.client()
+	if err != nil {
+		return 0, err
+	}
+	return client.NonceAt(ctx, account, blockNumber)
+}
+
+// SyncProgress returns the sync progress of the current node.
+func (c *FailoverClient) SyncProgress(
+	ctx context.Context) (*ethereum.SyncProgress, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SyncProgress(ctx)
+}
+
+// SubscribeNewHead subscribes to new block headers on the current node.
+// When the current node changes, the subscription is moved to the new
+// node. If the new node does not support subscriptions, the error
+// is delivered through the subscription.
+func (c *FailoverClient) SubscribeNewHead(ctx context.Context,
+	ch chan<- *types.Header) (ethereum.Subscription, error) {
+	c.mtx.RLock()
+	current, switched := c.current, c.switched
+	c.mtx.RUnlock()
+
+	if current.rank() == 0 {
+		return nil, ErrNoHealthyNode
+	}
+
+	sub, err := current.client.SubscribeNewHead(ctx, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &failoverSub{
+		err:  make(chan error, 1),
+		quit: make(chan struct{}),
+	}
+
+	go result.follow(ctx, c, ch, sub, switched)
+
+	return result, nil
+}
+
+// failoverSub is a subscription, which follows the current node.
+type failoverSub struct {
+	err  chan error
+	quit chan struct{}
+	once sync.Once
+}
+
+func (s *failoverSub) follow(ctx context.Context, c *FailoverClient,
+	ch chan<- *types.Header, sub ethereum.Subscription,
+	switched chan struct{}) {
+	defer close(s.err)
+
+	for {
+		select {
+		case err := <-sub.Err():
+			s.err <- err
+			return
+		case <-s.quit:
+			sub.Unsubscribe()
+			return
+		case <-switched:
+		}
+
+		sub.Unsubscribe()
+
+		c.mtx.RLock()
+		current := c.current
+		switched = c.switched
+		c.mtx.RUnlock()
+
+		log.Printf("moving new heads subscription to %s",
+			redactURL(current.url))
+
+		var err error
+		sub, err = current.client.SubscribeNewHead(ctx, ch)
+		if err != nil {
+			s.err <- err
+			return
+		}
+	}
+}
+
+// Unsubscribe cancels the subscription.
+func (s *failoverSub) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+}
+
+// Err returns the subscription error channel. It is closed
+// on Unsubscribe.
+func (s *failoverSub) Err() <-chan error {
+	return s.err
+}
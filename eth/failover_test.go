@@ -0,0 +1,183 @@
+package eth_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+func newFailoverClient(t *testing.T, urls ...string) *eth.FailoverClient {
+	cfg := config.NewConfig().Eth
+	cfg.NodeURL = urls
+	cfg.ChainID = 5
+	// Nodes are checked by the tests.
+	cfg.HealthCheckPause = uint64(time.Hour / time.Millisecond)
+
+	client, err := eth.NewFailoverClient(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+func TestNewFailoverClientConfig(t *testing.T) {
+	node := newTestNode(t, 5)
+
+	cfg := config.NewConfig().Eth
+	cfg.NodeURL = config.URLs{node.url(), node.url()}
+	if _, err := eth.NewFailoverClient(context.Background(), cfg,
+		nil); err == nil {
+		t.Fatal("expected error for several nodes without chain id")
+	}
+
+	cfg.ChainID = 5
+	cfg.HealthCheckPause = 0
+	if _, err := eth.NewFailoverClient(context.Background(), cfg,
+		nil); err == nil {
+		t.Fatal("expected error for zero health check pause")
+	}
+}
+
+func TestFailoverClientSelectsNode(t *testing.T) {
+	other := newTestNode(t, 7)
+	other.setHead(1000, 0)
+
+	a := newTestNode(t, 5)
+	b := newTestNode(t, 5)
+	b.setHead(150, 0)
+	b.syncing = true
+
+	client := newFailoverClient(t, other.url(), a.url(), b.url())
+
+	check := func(step string, exp *testNode) {
+		t.Helper()
+		if cur := eth.CurrentNode(client); cur != exp.url() {
+			t.Fatalf("%s: expected node %s, got %s", step, exp.url(), cur)
+		}
+	}
+
+	// A syncing node is used only if there are no synced nodes,
+	// a node on a different network is never used.
+	check("initial", a)
+
+	b.mtx.Lock()
+	b.syncing = false
+	b.mtx.Unlock()
+	eth.CheckNodes(client)
+	check("synced", b)
+
+	// A node, which is not ahead by more than one block, does not
+	// replace the current one.
+	a.setHead(151, 0)
+	eth.CheckNodes(client)
+	check("within tolerance", b)
+
+	b.setHead(150, time.Hour)
+	eth.CheckNodes(client)
+	check("stale head", a)
+
+	a.server.Close()
+	eth.CheckNodes(client)
+	check("unavailable", b)
+}
+
+func TestFailoverClientAccounts(t *testing.T) {
+	ctx := context.Background()
+
+	acc1 := common.HexToAddress("0x1")
+	acc2 := common.HexToAddress("0x2")
+
+	a := newTestNode(t, 5)
+	a.accounts = []common.Address{acc1}
+	b := newTestNode(t, 5)
+	b.accounts = []common.Address{acc1, acc2}
+
+	client := newFailoverClient(t, a.url(), b.url())
+
+	accounts, err := client.Accounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 2 ||
+		accounts[0] != "0x0000000000000000000000000000000000000001" ||
+		accounts[1] != "0x0000000000000000000000000000000000000002" {
+		t.Fatalf("unexpected accounts: %v", accounts)
+	}
+
+	// The account is unlocked on every node holding its key.
+	if err := client.UnlockAccount(ctx, acc2, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UnlockAccount(ctx, acc1, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.unlocked) != 1 || a.unlocked[0] != acc1 {
+		t.Fatalf("unexpected accounts unlocked on the first node: %v",
+			a.unlocked)
+	}
+	if len(b.unlocked) != 2 || b.unlocked[0] != acc2 ||
+		b.unlocked[1] != acc1 {
+		t.Fatalf("unexpected accounts unlocked on the second node: %v",
+			b.unlocked)
+	}
+}
+
+func TestFailoverClientSubscribeNewHead(t *testing.T) {
+	a := newTestNode(t, 5)
+	b := newTestNode(t, 5)
+
+	client := newFailoverClient(t, a.wsURL(), b.wsURL())
+
+	ch := make(chan *types.Header)
+	sub, err := client.SubscribeNewHead(context.Background(), ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	receive := func(from *testNode, number int64) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for from.subscribers() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("node has no subscribers")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		from.notify(&types.Header{
+			Number:     big.NewInt(number),
+			Difficulty: big.NewInt(1),
+		})
+
+		select {
+		case head := <-ch:
+			if head.Number.Int64() != number {
+				t.Fatalf("expected head %d, got %s", number, head.Number)
+			}
+		case err := <-sub.Err():
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("head %d was not received", number)
+		}
+	}
+
+	receive(a, 101)
+
+	b.setHead(200, 0)
+	eth.CheckNodes(client)
+
+	receive(b, 200)
+}
@@ -0,0 +1,199 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// FeeCaps limits fees of an outgoing transaction.
+// Nil values mean that the fee is not limited.
+type FeeCaps struct {
+	// MaxFeePerGas limits the total fee per gas in Wei.
+	// For legacy transactions it limits the gas price.
+	MaxFeePerGas *big.Int
+	// MaxPriorityFeePerGas limits the tip per gas in Wei.
+	MaxPriorityFeePerGas *big.Int
+}
+
+// Fees are fees of a transaction. Legacy transactions have only GasPrice,
+// EIP-1559 transactions have only GasFeeCap and GasTipCap.
+type Fees struct {
+	GasPrice  *big.Int
+	GasFeeCap *big.Int
+	GasTipCap *big.Int
+}
+
+// IsDynamic returns true if the fees belong to an EIP-1559 transaction.
+func (f *Fees) IsDynamic() bool {
+	return f.GasFeeCap != nil
+}
+
+// suggestFees returns transaction fees. For networks with EIP-1559
+// the fees are derived from eth_feeHistory, unless the legacy pricing
+// is enabled in configuration.
+func (c *GethClient) suggestFees(ctx context.Context,
+	caps *FeeCaps) (*Fees, error) {
+	if c.cfg.LegacyGasPrice {
+		return c.suggestLegacyFees(ctx, caps)
+	}
+
+	head, err := c.ethCli.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The network has not been upgraded to London.
+	if head.BaseFee == nil {
+		return c.suggestLegacyFees(ctx, caps)
+	}
+
+	history, err := c.ethCli.FeeHistory(ctx, c.cfg.FeeHistoryBlocks, nil,
+		[]float64{c.cfg.FeeHistoryPercentile})
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamicFees(history.BaseFee, history.Reward, caps)
+}
+
+func (c *GethClient) suggestLegacyFees(ctx context.Context,
+	caps *FeeCaps) (*Fees, error) {
+	gasPrice, err := c.ethCli.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if caps != nil && caps.MaxFeePerGas != nil &&
+		gasPrice.Cmp(caps.MaxFeePerGas) > 0 {
+		gasPrice = new(big.Int).Set(caps.MaxFeePerGas)
+	}
+
+	return &Fees{GasPrice: gasPrice}, nil
+}
+
+// dynamicFees calculates EIP-1559 fees. The tip is the median of the
+// rewards paid in recent blocks, the fee cap allows the base fee of the
+// next block to double.
+func dynamicFees(baseFees []*big.Int, rewards [][]*big.Int,
+	caps *FeeCaps) (*Fees, error) {
+	if len(baseFees) == 0 {
+		return nil, errors.New("empty fee history")
+	}
+
+	// The last base fee belongs to the next block.
+	baseFee := baseFees[len(baseFees)-1]
+
+	var tips []*big.Int
+	for k := range rewards {
+		if len(rewards[k]) != 0 && rewards[k][0] != nil {
+			tips = append(tips, rewards[k][0])
+		}
+	}
+
+	tip := new(big.Int)
+	if len(tips) != 0 {
+		sort.Slice(tips, func(i, j int) bool {
+			return tips[i].Cmp(tips[j]) < 0
+		})
+		tip.Set(tips[len(tips)/2])
+	}
+
+	if caps != nil && caps.MaxPriorityFeePerGas != nil &&
+		tip.Cmp(caps.MaxPriorityFeePerGas) > 0 {
+		tip.Set(caps.MaxPriorityFeePerGas)
+	}
+
+	feeCap := new(big.Int).Add(new(big.Int).Mul(baseFee, big.NewInt(2)), tip)
+
+	if caps != nil && caps.MaxFeePerGas != nil &&
+		feeCap.Cmp(caps.MaxFeePerGas) > 0 {
+		feeCap.Set(caps.MaxFeePerGas)
+	}
+
+	if tip.Cmp(feeCap) > 0 {
+		tip.Set(feeCap)
+	}
+
+	return &Fees{GasFeeCap: feeCap, GasTipCap: tip}, nil
+}
+
+// ErrFeeCapTooLow is returned when the fee caps do not allow
+// the minimal fee bump of a replacement transaction.
+var ErrFeeCapTooLow = errors.New(
+	"fee cap is lower than the minimal fee of a replacement transaction")
+
+// bumpFee increases the fee by the percent.
+func bumpFee(fee *big.Int, percent uint64) *big.Int {
+	result := new(big.Int).Mul(fee, new(big.Int).SetUint64(100+percent))
+	return result.Div(result, big.NewInt(100))
+}
+
+// maxBig returns the largest value, nil values are ignored.
+func maxBig(values ...*big.Int) *big.Int {
+	var result *big.Int
+	for _, v := range values {
+		if v != nil && (result == nil || v.Cmp(result) > 0) {
+			result = v
+		}
+	}
+	return new(big.Int).Set(result)
+}
+
+// capFee limits the fee by the cap. If the cap is lower than
+// the minimal fee, ErrFeeCapTooLow is returned.
+func capFee(fee, minFee, limit *big.Int) (*big.Int, error) {
+	if limit == nil || fee.Cmp(limit) <= 0 {
+		return fee, nil
+	}
+
+	if limit.Cmp(minFee) < 0 {
+		return nil, ErrFeeCapTooLow
+	}
+
+	return new(big.Int).Set(limit), nil
+}
+
+// replacementFees returns fees of a transaction which replaces
+// the original one. The fees of the original transaction are bumped
+// by the percent, but they are not lower than the suggested fees.
+// The type of the original transaction is kept.
+func replacementFees(original *types.Transaction, suggested *Fees,
+	percent uint64, caps *FeeCaps) (*Fees, error) {
+	if caps == nil {
+		caps = &FeeCaps{}
+	}
+
+	if original.Type() == types.LegacyTxType {
+		minPrice := bumpFee(original.GasPrice(), percent)
+		price, err := capFee(maxBig(minPrice, suggested.GasPrice,
+			suggested.GasFeeCap), minPrice, caps.MaxFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		return &Fees{GasPrice: price}, nil
+	}
+
+	minTip := bumpFee(original.GasTipCap(), percent)
+	tip, err := capFee(maxBig(minTip, suggested.GasTipCap), minTip,
+		caps.MaxPriorityFeePerGas)
+	if err != nil {
+		return nil, err
+	}
+
+	minFeeCap := bumpFee(original.GasFeeCap(), percent)
+	feeCap, err := capFee(maxBig(minFeeCap, suggested.GasFeeCap,
+		suggested.GasPrice), minFeeCap, caps.MaxFeePerGas)
+	if err != nil {
+		return nil, err
+	}
+
+	if tip.Cmp(feeCap) > 0 {
+		tip.Set(feeCap)
+	}
+
+	return &Fees{GasFeeCap: feeCap, GasTipCap: tip}, nil
+}
@@ -0,0 +1,297 @@
+package eth_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+func gwei(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e9))
+}
+
+func rewards(values ...int64) (result [][]*big.Int) {
+	for _, v := range values {
+		result = append(result, []*big.Int{gwei(v)})
+	}
+	return result
+}
+
+func TestDynamicFees(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		baseFees []*big.Int
+		rewards  [][]*big.Int
+		caps     *eth.FeeCaps
+		feeCap   *big.Int
+		tip      *big.Int
+		err      bool
+	}{
+		{
+			name:     "median tip",
+			baseFees: []*big.Int{gwei(10), gwei(12), gwei(11)},
+			rewards:  rewards(3, 1, 2),
+			feeCap:   gwei(24),
+			tip:      gwei(2),
+		},
+		{
+			name:     "empty rewards",
+			baseFees: []*big.Int{gwei(10), gwei(11)},
+			feeCap:   gwei(22),
+			tip:      new(big.Int),
+		},
+		{
+			name:     "blocks without rewards",
+			baseFees: []*big.Int{gwei(10), gwei(11)},
+			rewards:  [][]*big.Int{{}, {nil}, {gwei(4)}},
+			feeCap:   gwei(26),
+			tip:      gwei(4),
+		},
+		{
+			name:     "tip cap",
+			baseFees: []*big.Int{gwei(10)},
+			rewards:  rewards(5),
+			caps:     &eth.FeeCaps{MaxPriorityFeePerGas: gwei(1)},
+			feeCap:   gwei(21),
+			tip:      gwei(1),
+		},
+		{
+			name:     "fee cap lower than tip",
+			baseFees: []*big.Int{gwei(10)},
+			rewards:  rewards(5),
+			caps:     &eth.FeeCaps{MaxFeePerGas: gwei(3)},
+			feeCap:   gwei(3),
+			tip:      gwei(3),
+		},
+		{
+			name: "empty history",
+			err:  true,
+		},
+	} {
+		fees, err := eth.DynamicFees(c.baseFees, c.rewards, c.caps)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+
+		if fees.GasPrice != nil || fees.GasFeeCap.Cmp(c.feeCap) != 0 ||
+			fees.GasTipCap.Cmp(c.tip) != 0 {
+			t.Errorf("%s: expected fee cap %s and tip %s, got %+v",
+				c.name, c.feeCap, c.tip, fees)
+		}
+	}
+}
+
+func TestBumpFee(t *testing.T) {
+	for _, c := range []struct {
+		fee     int64
+		percent uint64
+		exp     int64
+	}{
+		{100, 10, 110},
+		{105, 10, 115},
+		{100, 0, 100},
+		{0, 10, 0},
+	} {
+		got := eth.BumpFee(big.NewInt(c.fee), c.percent)
+		if got.Int64() != c.exp {
+			t.Errorf("expected %d bumped by %d%% to be %d, got %s",
+				c.fee, c.percent, c.exp, got)
+		}
+	}
+}
+
+func TestCapFee(t *testing.T) {
+	for _, c := range []struct {
+		name               string
+		fee, minFee, limit *big.Int
+		exp                *big.Int
+		err                error
+	}{
+		{"no limit", gwei(10), gwei(5), nil, gwei(10), nil},
+		{"below limit", gwei(10), gwei(5), gwei(20), gwei(10), nil},
+		{"equal to limit", gwei(10), gwei(5), gwei(10), gwei(10), nil},
+		{"capped", gwei(10), gwei(5), gwei(7), gwei(7), nil},
+		{"capped to min fee", gwei(10), gwei(5), gwei(5), gwei(5), nil},
+		{"limit below min fee", gwei(10), gwei(5), gwei(4), nil,
+			eth.ErrFeeCapTooLow},
+	} {
+		fee, err := eth.CapFee(c.fee, c.minFee, c.limit)
+		if err != c.err {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+
+		if c.err == nil && fee.Cmp(c.exp) != 0 {
+			t.Errorf("%s: expected fee %s, got %s", c.name, c.exp, fee)
+		}
+	}
+}
+
+func TestReplacementFees(t *testing.T) {
+	legacy := types.NewTx(&types.LegacyTx{GasPrice: gwei(10)})
+	dynamic := types.NewTx(&types.DynamicFeeTx{
+		GasFeeCap: gwei(30),
+		GasTipCap: gwei(2),
+	})
+
+	for _, c := range []struct {
+		name      string
+		original  *types.Transaction
+		suggested *eth.Fees
+		caps      *eth.FeeCaps
+		exp       *eth.Fees
+		err       error
+	}{
+		{
+			name:      "legacy bumped",
+			original:  legacy,
+			suggested: &eth.Fees{GasPrice: gwei(5)},
+			exp:       &eth.Fees{GasPrice: gwei(11)},
+		},
+		{
+			name:      "legacy suggested",
+			original:  legacy,
+			suggested: &eth.Fees{GasPrice: gwei(15)},
+			exp:       &eth.Fees{GasPrice: gwei(15)},
+		},
+		{
+			name:     "legacy suggested dynamic fees",
+			original: legacy,
+			suggested: &eth.Fees{GasFeeCap: gwei(14),
+				GasTipCap: gwei(1)},
+			exp: &eth.Fees{GasPrice: gwei(14)},
+		},
+		{
+			name:      "legacy capped",
+			original:  legacy,
+			suggested: &eth.Fees{GasPrice: gwei(15)},
+			caps:      &eth.FeeCaps{MaxFeePerGas: gwei(12)},
+			exp:       &eth.Fees{GasPrice: gwei(12)},
+		},
+		{
+			name:      "legacy cap below bump",
+			original:  legacy,
+			suggested: &eth.Fees{GasPrice: gwei(5)},
+			caps:      &eth.FeeCaps{MaxFeePerGas: gwei(10)},
+			err:       eth.ErrFeeCapTooLow,
+		},
+		{
+			name:     "dynamic bumped",
+			original: dynamic,
+			suggested: &eth.Fees{GasFeeCap: gwei(20),
+				GasTipCap: gwei(1)},
+			exp: &eth.Fees{GasFeeCap: gwei(33), GasTipCap: big.NewInt(2.2e9)},
+		},
+		{
+			name:     "dynamic suggested",
+			original: dynamic,
+			suggested: &eth.Fees{GasFeeCap: gwei(40),
+				GasTipCap: gwei(3)},
+			exp: &eth.Fees{GasFeeCap: gwei(40), GasTipCap: gwei(3)},
+		},
+		{
+			name:      "dynamic suggested legacy fees",
+			original:  dynamic,
+			suggested: &eth.Fees{GasPrice: gwei(50)},
+			exp: &eth.Fees{GasFeeCap: gwei(50),
+				GasTipCap: big.NewInt(2.2e9)},
+		},
+		{
+			name:     "dynamic tip capped",
+			original: dynamic,
+			suggested: &eth.Fees{GasFeeCap: gwei(40),
+				GasTipCap: gwei(5)},
+			caps: &eth.FeeCaps{MaxFeePerGas: gwei(35),
+				MaxPriorityFeePerGas: gwei(4)},
+			exp: &eth.Fees{GasFeeCap: gwei(35), GasTipCap: gwei(4)},
+		},
+		{
+			name:     "dynamic fee cap below bump",
+			original: dynamic,
+			suggested: &eth.Fees{GasFeeCap: gwei(40),
+				GasTipCap: gwei(3)},
+			caps: &eth.FeeCaps{MaxFeePerGas: gwei(32)},
+			err:  eth.ErrFeeCapTooLow,
+		},
+		{
+			name:     "dynamic tip cap below bump",
+			original: dynamic,
+			suggested: &eth.Fees{GasFeeCap: gwei(40),
+				GasTipCap: gwei(3)},
+			caps: &eth.FeeCaps{MaxPriorityFeePerGas: gwei(2)},
+			err:  eth.ErrFeeCapTooLow,
+		},
+	} {
+		fees, err := eth.ReplacementFees(c.original, c.suggested, 10,
+			c.caps)
+		if err != c.err {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+
+		if c.err == nil && (!equalBig(fees.GasPrice, c.exp.GasPrice) ||
+			!equalBig(fees.GasFeeCap, c.exp.GasFeeCap) ||
+			!equalBig(fees.GasTipCap, c.exp.GasTipCap)) {
+			t.Errorf("%s: expected fees %+v, got %+v", c.name, c.exp, fees)
+		}
+	}
+}
+
+func equalBig(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
+
+func TestSuggestFees(t *testing.T) {
+	ctx := context.Background()
+	node := newTestNode(t, 1)
+	node.baseFees = []*big.Int{gwei(10), gwei(20)}
+	node.rewards = rewards(2)
+
+	client, err := eth.NewClient(ctx, node.url(), config.NewConfig().Eth,
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	fees, err := eth.SuggestFees(client, ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fees.IsDynamic() || fees.GasFeeCap.Cmp(gwei(42)) != 0 ||
+		fees.GasTipCap.Cmp(gwei(2)) != 0 {
+		t.Fatalf("unexpected fees: %+v", fees)
+	}
+
+	// The network has not been upgraded to London.
+	node.mtx.Lock()
+	node.head.BaseFee = nil
+	node.mtx.Unlock()
+
+	fees, err = eth.SuggestFees(client, ctx,
+		&eth.FeeCaps{MaxFeePerGas: gwei(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fees.IsDynamic() || fees.GasPrice.Cmp(gwei(1)) != 0 {
+		t.Fatalf("unexpected legacy fees: %+v", fees)
+	}
+}
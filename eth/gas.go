@@ -0,0 +1,138 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+)
+
+// ErrCodeGasEstimation is a JSON-RPC error code of gas estimation errors.
+const ErrCodeGasEstimation = -32050
+
+// GasEstimationError is an error of gas estimation. It is returned
+// to API clients as a structured JSON-RPC error.
+type GasEstimationError struct {
+	// Reason is a revert reason, if the transaction reverts.
+	Reason string `json:"reason,omitempty"`
+	// Data is raw revert data.
+	Data string `json:"data,omitempty"`
+	// Gas is an estimated gas, if it exceeds the gas limit cap.
+	Gas uint64 `json:"gas,omitempty"`
+	// GasLimitCap is a configured upper limit of gas.
+	GasLimitCap uint64 `json:"gasLimitCap,omitempty"`
+
+	err error
+}
+
+// Error returns the error message.
+func (e *GasEstimationError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("estimated gas %d exceeds the cap %d",
+			e.Gas, e.GasLimitCap)
+	}
+
+	return fmt.Sprintf("failed to estimate gas: %s", e.err)
+}
+
+// ErrorCode returns the JSON-RPC error code.
+func (e *GasEstimationError) ErrorCode() int {
+	return ErrCodeGasEstimation
+}
+
+// ErrorData returns the JSON-RPC error data.
+func (e *GasEstimationError) ErrorData() interface{} {
+	return e
+}
+
+// Unwrap returns the underlying error.
+func (e *GasEstimationError) Unwrap() error {
+	return e.err
+}
+
+func newGasEstimationError(err error) *GasEstimationError {
+	result := &GasEstimationError{err: err}
+
+	dataErr, ok := err.(rpc.DataError)
+	if !ok {
+		return result
+	}
+
+	data, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return result
+	}
+
+	result.Data = data
+
+	raw, err := hexutil.Decode(data)
+	if err != nil {
+		return result
+	}
+
+	if reason, err := abi.UnpackRevert(raw); err == nil {
+		result.Reason = reason
+	}
+
+	return result
+}
+
+// estimateGas estimates gas of a transfer with eth_estimateGas and
+// applies the safety multiplier and the upper cap from configuration.
+func (c *GethClient) estimateGas(ctx context.Context,
+	from, to common.Address, amount *big.Int, fees *Fees) (uint64, error) {
+	msg := ethereum.CallMsg{
+		From:      from,
+		To:        &to,
+		Value:     amount,
+		GasPrice:  fees.GasPrice,
+		GasFeeCap: fees.GasFeeCap,
+		GasTipCap: fees.GasTipCap,
+	}
+
+	estimated, err := c.ethCli.EstimateGas(ctx, msg)
+	if err != nil {
+		return 0, newGasEstimationError(err)
+	}
+
+	return applyGasLimits(estimated, c.cfg.GasMultiplier, c.cfg.GasLimitCap)
+}
+
+// applyGasLimits multiplies the estimated gas by the multiplier and
+// limits the result by the cap. Zero cap means no limit.
+func applyGasLimits(estimated uint64, multiplier float64,
+	limitCap uint64) (uint64, error) {
+	if limitCap != 0 && estimated > limitCap {
+		return 0, &GasEstimationError{Gas: estimated, GasLimitCap: limitCap}
+	}
+
+	gas := estimated
+	if multiplier > 1 {
+		gas = uint64(float64(estimated) * multiplier)
+	}
+
+	if limitCap != 0 && gas > limitCap {
+		gas = limitCap
+	}
+
+	return gas, nil
+}
+
+// TransferGas returns the gas limit of an ETH transfer to an account,
+// which is not a contract.
+func TransferGas(cfg *config.Eth) uint64 {
+	gas, err := applyGasLimits(params.TxGas, cfg.GasMultiplier,
+		cfg.GasLimitCap)
+	if err != nil {
+		return params.TxGas
+	}
+	return gas
+}
@@ -0,0 +1,107 @@
+package eth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+func TestApplyGasLimits(t *testing.T) {
+	for _, c := range []struct {
+		estimated  uint64
+		multiplier float64
+		limitCap   uint64
+		exp        uint64
+		err        bool
+	}{
+		{21000, 0, 0, 21000, false},
+		{21000, 1, 0, 21000, false},
+		{100000, 1.2, 0, 120000, false},
+		{100000, 1.2, 110000, 110000, false},
+		{100000, 1.2, 200000, 120000, false},
+		{100000, 1.2, 90000, 0, true},
+	} {
+		gas, err := eth.ApplyGasLimits(c.estimated, c.multiplier,
+			c.limitCap)
+		if c.err {
+			var gasErr *eth.GasEstimationError
+			if !errors.As(err, &gasErr) || gasErr.Gas != c.estimated ||
+				gasErr.GasLimitCap != c.limitCap {
+				t.Errorf("expected gas estimation error for %d with"+
+					" cap %d, got %v", c.estimated, c.limitCap, err)
+			}
+			continue
+		}
+
+		if err != nil || gas != c.exp {
+			t.Errorf("expected %d gas for %d x %v with cap %d, got %d %v",
+				c.exp, c.estimated, c.multiplier, c.limitCap, gas, err)
+		}
+	}
+}
+
+type testDataError struct {
+	data interface{}
+}
+
+func (e *testDataError) Error() string {
+	return "execution reverted"
+}
+
+func (e *testDataError) ErrorData() interface{} {
+	return e.data
+}
+
+func revertData(t *testing.T, reason string) string {
+	stringType, err := abi.NewType("string", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packed, err := abi.Arguments{{Type: stringType}}.Pack(reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selector := crypto.Keccak256([]byte("Error(string)"))[:4]
+	return hexutil.Encode(append(selector, packed...))
+}
+
+func TestNewGasEstimationError(t *testing.T) {
+	reverted := revertData(t, "insufficient allowance")
+
+	for _, c := range []struct {
+		name   string
+		err    error
+		reason string
+		data   string
+	}{
+		{"plain error", errors.New("connection refused"), "", ""},
+		{"revert reason", &testDataError{reverted},
+			"insufficient allowance", reverted},
+		{"custom error", &testDataError{"0xdeadbeef"}, "", "0xdeadbeef"},
+		{"invalid data", &testDataError{"revert"}, "", "revert"},
+		{"non-string data", &testDataError{42}, "", ""},
+	} {
+		gasErr := eth.NewGasEstimationError(c.err)
+
+		if gasErr.Reason != c.reason || gasErr.Data != c.data {
+			t.Errorf("%s: expected reason %q and data %q, got %q %q",
+				c.name, c.reason, c.data, gasErr.Reason, gasErr.Data)
+		}
+
+		if !errors.Is(gasErr, c.err) {
+			t.Errorf("%s: error is not wrapped", c.name)
+		}
+
+		if gasErr.ErrorCode() != eth.ErrCodeGasEstimation {
+			t.Errorf("%s: unexpected error code %d", c.name,
+				gasErr.ErrorCode())
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package eth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Errors of extended keys.
+var (
+	ErrInvalidExtendedKey = errors.New("invalid extended public key")
+	ErrHardenedChild      = errors.New(
+		"hardened child can not be derived from a public key")
+	ErrInvalidChild = errors.New("invalid child, use the next index")
+)
+
+// HardenedIndex is the first index of hardened children.
+const HardenedIndex uint32 = 0x80000000
+
+const (
+	extendedKeyLen = 78
+	checksumLen    = 4
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// ExtendedKey is a BIP-32 extended public key. Only non-hardened
+// children can be derived, private keys are never needed.
+type ExtendedKey struct {
+	version     [4]byte
+	depth       byte
+	fingerprint [4]byte
+	index       uint32
+	chainCode   []byte
+	pubKey      []byte
+}
+
+// ParseExtendedKey parses a Base58Check encoded extended public key
+// (xpub).
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	raw, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) != extendedKeyLen+checksumLen {
+		return nil, ErrInvalidExtendedKey
+	}
+
+	payload, checksum := raw[:extendedKeyLen], raw[extendedKeyLen:]
+	if !bytes.Equal(doubleSHA256(payload)[:checksumLen], checksum) {
+		return nil, errors.Wrap(ErrInvalidExtendedKey, "bad checksum")
+	}
+
+	key := &ExtendedKey{
+		depth:     payload[4],
+		index:     binary.BigEndian.Uint32(payload[9:13]),
+		chainCode: payload[13:45],
+		pubKey:    payload[45:78],
+	}
+	copy(key.version[:], payload[:4])
+	copy(key.fingerprint[:], payload[5:9])
+
+	if _, err := crypto.DecompressPubkey(key.pubKey); err != nil {
+		return nil, errors.Wrap(ErrInvalidExtendedKey,
+			"key is not a public key")
+	}
+
+	return key, nil
+}
+
+// Child derives a non-hardened child key.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if index >= HardenedIndex {
+		return nil, ErrHardenedChild
+	}
+
+	seed := make([]byte, len(k.pubKey)+4)
+	copy(seed, k.pubKey)
+	binary.BigEndian.PutUint32(seed[len(k.pubKey):], index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	curve := crypto.S256()
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	parent, err := crypto.DecompressPubkey(k.pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := curve.ScalarBaseMult(sum[:32])
+	x, y = curve.Add(x, y, parent.X, parent.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidChild
+	}
+
+	child := &ExtendedKey{
+		version:   k.version,
+		depth:     k.depth + 1,
+		index:     index,
+		chainCode: sum[32:],
+		pubKey: crypto.CompressPubkey(
+			&ecdsa.PublicKey{Curve: curve, X: x, Y: y}),
+	}
+	copy(child.fingerprint[:], hash160(k.pubKey)[:4])
+
+	return child, nil
+}
+
+// Address returns the Ethereum address of the key.
+func (k *ExtendedKey) Address() common.Address {
+	pub, _ := crypto.DecompressPubkey(k.pubKey)
+	return crypto.PubkeyToAddress(*pub)
+}
+
+// String returns the Base58Check encoded key.
+func (k *ExtendedKey) String() string {
+	payload := make([]byte, extendedKeyLen)
+	copy(payload, k.version[:])
+	payload[4] = k.depth
+	copy(payload[5:9], k.fingerprint[:])
+	binary.BigEndian.PutUint32(payload[9:13], k.index)
+	copy(payload[13:45], k.chainCode)
+	copy(payload[45:], k.pubKey)
+
+	return base58Encode(append(payload,
+		doubleSHA256(payload)[:checksumLen]...))
+}
+
+// DepositWallet derives deposit addresses from an extended public key
+// by BIP-44: the address with the index has the path <base>/0/<index>.
+type DepositWallet struct {
+	external *ExtendedKey
+	basePath string
+}
+
+// NewDepositWallet creates a new deposit wallet. The base path is the
+// derivation path of the extended public key.
+func NewDepositWallet(xpub, basePath string) (*DepositWallet, error) {
+	key, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+
+	external, err := key.Child(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DepositWallet{
+		external: external,
+		basePath: strings.TrimRight(basePath, "/"),
+	}, nil
+}
+
+// Derive returns the deposit address with the index
+// and its derivation path.
+func (w *DepositWallet) Derive(index uint32) (common.Address, string,
+	error) {
+	key, err := w.external.Child(index)
+	if err != nil {
+		return common.Address{}, "", err
+	}
+
+	return key.Address(), fmt.Sprintf("%s/0/%d", w.basePath, index), nil
+}
+
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+func hash160(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	h := ripemd160.New()
+	h.Write(sum[:])
+	return h.Sum(nil)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	result := new(big.Int)
+	radix := big.NewInt(58)
+
+	for _, r := range s {
+		i := strings.IndexRune(base58Alphabet, r)
+		if i < 0 {
+			return nil, errors.Wrapf(ErrInvalidExtendedKey,
+				"invalid character %q", r)
+		}
+		result.Mul(result, radix)
+		result.Add(result, big.NewInt(int64(i)))
+	}
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), result.Bytes()...), nil
+}
+
+func base58Encode(b []byte) string {
+	value := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var result []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, radix, mod)
+		result = append(result, base58Alphabet[mod.Int64()])
+	}
+
+	for i := 0; i < len(b) && b[i] == 0; i++ {
+		result = append(result, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return string(result)
+}
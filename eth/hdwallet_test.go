@@ -0,0 +1,101 @@
+package eth_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+// hardhatXpub is the extended public key of m/44'/60'/0' of the mnemonic
+// "test test test test test test test test test test test junk".
+const hardhatXpub = "xpub6Ce9NcJvTk36xtLSrJLZqE7wtgA5deCeYs7rSQtreh4cj6ByPtrg9sD7V2FNFLPnf8heNP3FGkeV9qwfzvZNSd54JoNXVsXFYSYwHsnJxqP"
+
+func TestExtendedKeyChild(t *testing.T) {
+	// BIP-32 test vector 1: m/0'/1/2'/2 and m/0'/1/2'/2/1000000000.
+	parent := "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV"
+	exp := "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy"
+
+	key, err := eth.ParseExtendedKey(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.String() != parent {
+		t.Fatalf("expected %s, got %s", parent, key.String())
+	}
+
+	child, err := key.Child(1000000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if child.String() != exp {
+		t.Fatalf("expected %s, got %s", exp, child.String())
+	}
+
+	if _, err := key.Child(eth.HardenedIndex); err != eth.ErrHardenedChild {
+		t.Fatalf("expected %v, got %v", eth.ErrHardenedChild, err)
+	}
+}
+
+func TestExtendedKeyRoundTrip(t *testing.T) {
+	for _, xpub := range []string{
+		hardhatXpub,
+		// BIP-32 test vector 1: m and m/0'/1/2'/2.
+		"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+		"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+	} {
+		key, err := eth.ParseExtendedKey(xpub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key.String() != xpub {
+			t.Fatalf("expected %s, got %s", xpub, key.String())
+		}
+	}
+
+	// The last character breaks the checksum.
+	invalid := hardhatXpub[:len(hardhatXpub)-1] + "Q"
+	if _, err := eth.ParseExtendedKey(invalid); err == nil {
+		t.Fatal("expected an error for an invalid checksum")
+	}
+}
+
+func TestDepositWalletDerive(t *testing.T) {
+	const basePath = "m/44'/60'/0'"
+
+	// The well-known accounts of the Hardhat network.
+	exp := []string{
+		"0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+		"0x70997970C51812dc3A010C7d01b50e0d17dc79C8",
+		"0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC",
+		"0x90F79bf6EB2c4f870365E785982E1f101E93b906",
+		"0x15d34AAf54267DB7D7c367839AAf71A00a2C6A65",
+	}
+
+	wallet, err := eth.NewDepositWallet(hardhatXpub, basePath+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, address := range exp {
+		addr, path, err := wallet.Derive(uint32(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if addr != common.HexToAddress(address) {
+			t.Fatalf("index %d: expected %s, got %s",
+				index, address, addr.String())
+		}
+
+		if expPath := fmt.Sprintf("%s/0/%d", basePath, index); path != expPath {
+			t.Fatalf("index %d: expected path %s, got %s",
+				index, expPath, path)
+		}
+	}
+}
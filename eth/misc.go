@@ -5,6 +5,8 @@ import (
 	"log"
 	"math/big"
 	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
 // WaitSync waits for the synchronization of the Geth node.
@@ -39,3 +41,38 @@ func WaitSync(ctx context.Context, client Client,
 
 	return nil
 }
+
+// BlockByTime returns the last block mined not later than the Unix time.
+// If the time is earlier than the first block, the genesis block
+// is returned.
+func BlockByTime(ctx context.Context, client Client,
+	timestamp uint64) (*types.Block, error) {
+	last, err := client.BlockByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if last.Time() <= timestamp {
+		return last, nil
+	}
+
+	// Blocks have increasing timestamps, the result is in [low, high).
+	low, high := uint64(0), last.NumberU64()
+	for low+1 < high {
+		middle := low + (high-low)/2
+
+		block, err := client.BlockByNumber(ctx,
+			new(big.Int).SetUint64(middle))
+		if err != nil {
+			return nil, err
+		}
+
+		if block.Time() <= timestamp {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	return client.BlockByNumber(ctx, new(big.Int).SetUint64(low))
+}
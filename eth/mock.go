@@ -4,30 +4,159 @@ import (
 	"context"
 	"math/big"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
 )
 
-// MockClient is a mock for Ethereum client.
+// MockChainID is a chain ID of the simulated blockchain.
+var MockChainID = big.NewInt(1337)
+
+// mockGasLimit is a gas limit of blocks of the simulated blockchain.
+const mockGasLimit uint64 = 8000000
+
+// mockFeeBumpPercent is a fee increase of replacement transactions.
+const mockFeeBumpPercent = 10
+
+// MockClient is a fake Ethereum client backed by a simulated blockchain.
+// Blocks are mined on demand, receipts and balances are real.
+// Tests can script chain reorganizations, RPC errors and latency.
 type MockClient struct {
 	Acc     map[string]*bind.TransactOpts
 	NetID   *big.Int
-	Block   *types.Block
 	Backend *backends.SimulatedBackend
+
+	// AutoMine mines a block after each sent transaction.
+	AutoMine bool
+	// BlockReceipts enables eth_getBlockReceipts emulation.
+	BlockReceiptsSupported bool
+
+	mtx       sync.Mutex
+	errs      map[string][]error
+	latency   time.Duration
+	traces    map[uint64][]*TxTrace
+	passwords map[string]string
+	locked    map[string]bool
+	// pending are transactions sent through the mock since the last
+	// mined block.
+	pending []*types.Transaction
+}
+
+// NewMockClient creates a new fake Ethereum client. Each account gets
+// the balance in the genesis block.
+func NewMockClient(balance *big.Int,
+	accounts ...*bind.TransactOpts) *MockClient {
+	acc := make(map[string]*bind.TransactOpts)
+	alloc := make(core.GenesisAlloc)
+
+	for _, opts := range accounts {
+		acc[strings.ToLower(opts.From.String())] = opts
+		alloc[opts.From] = core.GenesisAccount{Balance: balance}
+	}
+
+	return &MockClient{
+		Acc:       acc,
+		NetID:     MockChainID,
+		Backend:   backends.NewSimulatedBackend(alloc, mockGasLimit),
+		AutoMine:  true,
+		errs:      make(map[string][]error),
+		traces:    make(map[uint64][]*TxTrace),
+		passwords: make(map[string]string),
+		locked:    make(map[string]bool),
+	}
 }
 
-// NewMockClient creates a new Ethereum client.
-func NewMockClient() *MockClient {
-	return &MockClient{}
+// FailNext makes the next call of the method return the error.
+// Several errors for the same method are returned in order.
+func (c *MockClient) FailNext(method string, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.errs[method] = append(c.errs[method], err)
+}
+
+// SetLatency sets a delay of every call.
+func (c *MockClient) SetLatency(latency time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.latency = latency
+}
+
+// SetTraces sets call traces of the block transactions.
+func (c *MockClient) SetTraces(number uint64, traces []*TxTrace) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.traces[number] = traces
+}
+
+// Mine mines a block with pending transactions.
+func (c *MockClient) Mine() *types.Block {
+	c.mtx.Lock()
+	c.pending = nil
+	c.mtx.Unlock()
+
+	hash := c.Backend.Commit()
+	block, _ := c.Backend.BlockByHash(context.Background(), hash)
+	return block
+}
+
+// Fork starts a side chain from the block with the number. The side
+// chain becomes canonical when it is longer than the current chain.
+func (c *MockClient) Fork(number uint64) error {
+	ctx := context.Background()
+
+	parent, err := c.Backend.BlockByNumber(ctx,
+		new(big.Int).SetUint64(number))
+	if err != nil {
+		return err
+	}
+
+	return c.Backend.Fork(ctx, parent.Hash())
+}
+
+// call emulates a network call: it waits for the latency
+// and returns a scripted error of the method if there is one.
+func (c *MockClient) call(ctx context.Context, method string) error {
+	c.mtx.Lock()
+	latency := c.latency
+	var err error
+	if errs := c.errs[method]; len(errs) != 0 {
+		err, c.errs[method] = errs[0], errs[1:]
+	}
+	c.mtx.Unlock()
+
+	if latency != 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
 }
 
 // Accounts is a mock for Accounts function.
 func (c *MockClient) Accounts(
 	ctx context.Context) (result []string, err error) {
+	if err := c.call(ctx, "Accounts"); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
 	for k := range c.Acc {
 		result = append(result, k)
 	}
@@ -35,66 +164,385 @@ func (c *MockClient) Accounts(
 	return result, nil
 }
 
+// NewAccount is a mock for NewAccount function.
+// New accounts are locked, like on Geth node.
+func (c *MockClient) NewAccount(ctx context.Context,
+	password string) (common.Address, error) {
+	if err := c.call(ctx, "NewAccount"); err != nil {
+		return common.Address{}, err
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, c.NetID)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	addr := strings.ToLower(opts.From.String())
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.Acc[addr] = opts
+	c.passwords[addr] = password
+	c.locked[addr] = true
+
+	return opts.From, nil
+}
+
+// UnlockAccount is a mock for UnlockAccount function.
+func (c *MockClient) UnlockAccount(ctx context.Context,
+	account common.Address, password string, duration *uint64) error {
+	if err := c.call(ctx, "UnlockAccount"); err != nil {
+		return err
+	}
+
+	addr := strings.ToLower(account.String())
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.Acc[addr]; !ok {
+		return errors.New("no key for given address or file")
+	}
+
+	if expected, ok := c.passwords[addr]; ok && expected != password {
+		return errors.New("could not decrypt key with given password")
+	}
+
+	c.locked[addr] = false
+	return nil
+}
+
+// LockAccount is a mock for LockAccount function.
+func (c *MockClient) LockAccount(ctx context.Context,
+	account common.Address) error {
+	if err := c.call(ctx, "LockAccount"); err != nil {
+		return err
+	}
+
+	addr := strings.ToLower(account.String())
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.Acc[addr]; !ok {
+		return errors.New("no key for given address or file")
+	}
+
+	c.locked[addr] = true
+	return nil
+}
+
 // SendTransaction is a mock for SendTransaction function.
-func (c *MockClient) SendTransaction(ctx context.Context,
-	from, to common.Address, amount *big.Int) (result *string, err error) {
-	gasLimit := uint64(4700000)
+func (c *MockClient) SendTransaction(ctx context.Context, from,
+	to common.Address, amount *big.Int,
+	caps *FeeCaps) (result *string, err error) {
+	if err := c.call(ctx, "SendTransaction"); err != nil {
+		return nil, err
+	}
 
-	acc := c.Acc[strings.ToLower(from.String())]
+	gasLimit := uint64(21000)
+
+	c.mtx.Lock()
+	acc, ok := c.Acc[strings.ToLower(from.String())]
+	locked := c.locked[strings.ToLower(from.String())]
+	c.mtx.Unlock()
+
+	if !ok {
+		return nil, errors.Errorf("unknown account %s", from.String())
+	}
 
-	nonce, err := c.Backend.NonceAt(context.Background(), acc.From, nil)
+	if locked {
+		return nil, errors.New("authentication needed: password or unlock")
+	}
+
+	nonce, err := c.Backend.PendingNonceAt(ctx, acc.From)
 	if err != nil {
 		return nil, err
 	}
 
-	gasPrice, err := c.Backend.SuggestGasPrice(context.Background())
+	gasPrice, err := c.Backend.SuggestGasPrice(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	if caps != nil && caps.MaxFeePerGas != nil &&
+		gasPrice.Cmp(caps.MaxFeePerGas) > 0 {
+		gasPrice = caps.MaxFeePerGas
+	}
+
 	rawTx := types.NewTransaction(nonce, to,
 		amount, gasLimit, gasPrice, nil)
 
 	signTx, err := acc.Signer(acc.From, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := c.Backend.SendTransaction(ctx, signTx); err != nil {
 		return nil, err
 	}
 
-	c.Backend.Commit()
+	if c.AutoMine {
+		c.Mine()
+	} else {
+		c.mtx.Lock()
+		c.pending = append(c.pending, signTx)
+		c.mtx.Unlock()
+	}
 
 	hash := strings.ToLower(signTx.Hash().String())
 	return &hash, nil
 }
 
+// ReplaceTransaction is a mock for ReplaceTransaction function.
+// Only transactions sent through the mock can be replaced. The simulated
+// backend does not accept transactions with the nonce of a pending
+// transaction, so the pending block is rebuilt with the replacement
+// instead of the original transaction.
+func (c *MockClient) ReplaceTransaction(ctx context.Context,
+	from common.Address, hash common.Hash, cancel bool,
+	caps *FeeCaps) (*string, error) {
+	if err := c.call(ctx, "ReplaceTransaction"); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	acc, ok := c.Acc[strings.ToLower(from.String())]
+	if !ok {
+		return nil, errors.Errorf("unknown account %s", from.String())
+	}
+
+	idx := -1
+	for k, tx := range c.pending {
+		if tx.Hash() == hash {
+			idx = k
+		}
+	}
+	if idx < 0 {
+		return nil, ErrTxNotPending
+	}
+	original := c.pending[idx]
+
+	gasPrice, err := c.Backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fees, err := replacementFees(original, &Fees{GasPrice: gasPrice},
+		mockFeeBumpPercent, caps)
+	if err != nil {
+		return nil, err
+	}
+
+	to, amount, gas := original.To(), original.Value(), original.Gas()
+	if cancel {
+		to, amount, gas = &from, new(big.Int), params.TxGas
+	}
+
+	var rawTx *types.Transaction
+	if fees.IsDynamic() {
+		rawTx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:   c.NetID,
+			Nonce:     original.Nonce(),
+			GasTipCap: fees.GasTipCap,
+			GasFeeCap: fees.GasFeeCap,
+			Gas:       gas,
+			To:        to,
+			Value:     amount,
+		})
+	} else {
+		rawTx = types.NewTransaction(original.Nonce(), *to, amount, gas,
+			fees.GasPrice, nil)
+	}
+
+	signTx, err := acc.Signer(acc.From, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := append([]*types.Transaction{}, c.pending...)
+	pending[idx] = signTx
+
+	c.Backend.Rollback()
+	for _, tx := range pending {
+		if err := c.Backend.SendTransaction(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+	c.pending = pending
+
+	result := strings.ToLower(signTx.Hash().String())
+	return &result, nil
+}
+
+// PendingNonceAt is a mock for PendingNonceAt function.
+func (c *MockClient) PendingNonceAt(ctx context.Context,
+	account common.Address) (uint64, error) {
+	if err := c.call(ctx, "PendingNonceAt"); err != nil {
+		return 0, err
+	}
+
+	return c.Backend.PendingNonceAt(ctx, account)
+}
+
 // NetworkID is a mock for NetworkID function.
 func (c *MockClient) NetworkID(ctx context.Context) (*big.Int, error) {
+	if err := c.call(ctx, "NetworkID"); err != nil {
+		return nil, err
+	}
+
 	return c.NetID, nil
 }
 
 // BlockByNumber is a mock for BlockByNumber function.
 func (c *MockClient) BlockByNumber(ctx context.Context,
 	number *big.Int) (*types.Block, error) {
-	return c.Block, nil
+	if err := c.call(ctx, "BlockByNumber"); err != nil {
+		return nil, err
+	}
+
+	return c.Backend.BlockByNumber(ctx, number)
+}
+
+// TransactionByHash is a mock for TransactionByHash function.
+func (c *MockClient) TransactionByHash(ctx context.Context,
+	hash common.Hash) (*types.Transaction, bool, error) {
+	if err := c.call(ctx, "TransactionByHash"); err != nil {
+		return nil, false, err
+	}
+
+	return c.Backend.TransactionByHash(ctx, hash)
 }
 
 // TransactionReceipt is a mock for TransactionReceipt function.
 func (c *MockClient) TransactionReceipt(ctx context.Context,
 	txHash common.Hash) (*types.Receipt, error) {
-	return c.TransactionReceipt(ctx, txHash)
+	if err := c.call(ctx, "TransactionReceipt"); err != nil {
+		return nil, err
+	}
+
+	return c.Backend.TransactionReceipt(ctx, txHash)
+}
+
+// TransactionReceipts is a mock for TransactionReceipts function.
+func (c *MockClient) TransactionReceipts(ctx context.Context,
+	hashes []common.Hash) (result []*types.Receipt, err error) {
+	if err := c.call(ctx, "TransactionReceipts"); err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		receipt, err := c.Backend.TransactionReceipt(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, receipt)
+	}
+	return result, nil
+}
+
+// BlockReceipts is a mock for BlockReceipts function.
+func (c *MockClient) BlockReceipts(ctx context.Context,
+	hash common.Hash) (result []*types.Receipt, err error) {
+	if !c.BlockReceiptsSupported {
+		return nil, ErrBlockReceiptsUnsupported
+	}
+
+	if err := c.call(ctx, "BlockReceipts"); err != nil {
+		return nil, err
+	}
+
+	block, err := c.Backend.BlockByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions() {
+		receipt, err := c.Backend.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, receipt)
+	}
+	return result, nil
+}
+
+// FilterLogs is a mock for FilterLogs function.
+func (c *MockClient) FilterLogs(ctx context.Context,
+	query ethereum.FilterQuery) ([]types.Log, error) {
+	if err := c.call(ctx, "FilterLogs"); err != nil {
+		return nil, err
+	}
+
+	return c.Backend.FilterLogs(ctx, query)
+}
+
+// TraceBlock is a mock for TraceBlock function.
+// It returns the traces set by SetTraces.
+func (c *MockClient) TraceBlock(ctx context.Context,
+	number *big.Int) ([]*TxTrace, error) {
+	if err := c.call(ctx, "TraceBlock"); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	traces, ok := c.traces[number.Uint64()]
+	if !ok {
+		return nil, errors.Errorf("no traces of block %s", number)
+	}
+	return traces, nil
 }
 
 // BalanceAt is a mock for BalanceAt function.
 func (c *MockClient) BalanceAt(ctx context.Context, account common.Address,
 	blockNumber *big.Int) (*big.Int, error) {
-	return c.BalanceAt(ctx, account, blockNumber)
+	if err := c.call(ctx, "BalanceAt"); err != nil {
+		return nil, err
+	}
+
+	return c.Backend.BalanceAt(ctx, account, blockNumber)
+}
+
+// NonceAt is a mock for NonceAt function.
+func (c *MockClient) NonceAt(ctx context.Context, account common.Address,
+	blockNumber *big.Int) (uint64, error) {
+	if err := c.call(ctx, "NonceAt"); err != nil {
+		return 0, err
+	}
+
+	return c.Backend.NonceAt(ctx, account, blockNumber)
 }
 
 // SyncProgress is a mock for SyncProgress function.
 func (c *MockClient) SyncProgress(
 	ctx context.Context) (*ethereum.SyncProgress, error) {
+	if err := c.call(ctx, "SyncProgress"); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
+// SubscribeNewHead is a mock for SubscribeNewHead function.
+func (c *MockClient) SubscribeNewHead(ctx context.Context,
+	ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if err := c.call(ctx, "SubscribeNewHead"); err != nil {
+		return nil, err
+	}
+
+	return c.Backend.SubscribeNewHead(ctx, ch)
+}
+
 // NewTestBlock creates a new test block.
 func NewTestBlock(number *big.Int, txs []*types.Transaction,
 	trx []*types.Receipt) *types.Block {
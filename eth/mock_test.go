@@ -0,0 +1,197 @@
+package eth_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+func newMockClient(t *testing.T) (*eth.MockClient, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, eth.MockChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return eth.NewMockClient(big.NewInt(1e18), opts), opts.From
+}
+
+func TestMockClientReorg(t *testing.T) {
+	ctx := context.Background()
+	client, from := newMockClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := client.TransactionReceipt(ctx, common.HexToHash(*hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if receipt.BlockNumber.Uint64() != 1 {
+		t.Fatalf("expected block 1, got %s", receipt.BlockNumber)
+	}
+
+	balance, err := client.BalanceAt(ctx, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Int64() != 1000 {
+		t.Fatalf("expected balance 1000, got %s", balance)
+	}
+
+	orphaned, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Fork(0); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Mine()
+	client.Mine()
+
+	block, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.Hash() == orphaned.Hash() {
+		t.Fatal("chain has not been reorganized")
+	}
+
+	if _, err := client.TransactionReceipt(ctx,
+		common.HexToHash(*hash)); err != ethereum.NotFound {
+		t.Fatalf("expected %v, got %v", ethereum.NotFound, err)
+	}
+
+	balance, err = client.BalanceAt(ctx, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Sign() != 0 {
+		t.Fatalf("expected zero balance, got %s", balance)
+	}
+}
+
+func TestMockClientFailNext(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMockClient(t)
+
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+
+	client.FailNext("BlockByNumber", errFirst)
+	client.FailNext("BlockByNumber", errSecond)
+
+	for _, exp := range []error{errFirst, errSecond, nil} {
+		if _, err := client.BlockByNumber(ctx, nil); err != exp {
+			t.Fatalf("expected %v, got %v", exp, err)
+		}
+	}
+}
+
+func TestMockClientReplaceTransaction(t *testing.T) {
+	ctx := context.Background()
+	client, from := newMockClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	client.AutoMine = false
+
+	first, err := client.SendTransaction(ctx, from, to, big.NewInt(1000),
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := client.SendTransaction(ctx, from, to, big.NewInt(2000),
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, _, err := client.TransactionByHash(ctx,
+		common.HexToHash(*first))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sped, err := client.ReplaceTransaction(ctx, from,
+		common.HexToHash(*first), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := client.TransactionByHash(ctx,
+		common.HexToHash(*first)); err != ethereum.NotFound {
+		t.Fatalf("expected replaced transaction to be removed, got %v",
+			err)
+	}
+
+	replacement, pending, err := client.TransactionByHash(ctx,
+		common.HexToHash(*sped))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pending || replacement.Nonce() != original.Nonce() ||
+		replacement.Value().Cmp(original.Value()) != 0 ||
+		replacement.GasPrice().Cmp(original.GasPrice()) <= 0 {
+		t.Fatalf("unexpected replacement: %+v", replacement)
+	}
+
+	cancelled, err := client.ReplaceTransaction(ctx, from,
+		common.HexToHash(*second), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Mine()
+
+	for _, hash := range []string{*first, *second} {
+		if _, err := client.TransactionReceipt(ctx,
+			common.HexToHash(hash)); err != ethereum.NotFound {
+			t.Fatalf("expected replaced transaction %s not to be mined,"+
+				" got %v", hash, err)
+		}
+	}
+
+	for _, hash := range []string{*sped, *cancelled} {
+		if _, err := client.TransactionReceipt(ctx,
+			common.HexToHash(hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	balance, err := client.BalanceAt(ctx, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Int64() != 1000 {
+		t.Fatalf("expected balance 1000, got %s", balance)
+	}
+
+	if _, err := client.ReplaceTransaction(ctx, from,
+		common.HexToHash(*sped), false, nil); err != eth.ErrTxNotPending {
+		t.Fatalf("expected %v, got %v", eth.ErrTxNotPending, err)
+	}
+}
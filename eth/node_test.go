@@ -0,0 +1,248 @@
+package eth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testNode is a fake Ethereum node served over HTTP.
+type testNode struct {
+	mtx      sync.Mutex
+	chainID  *big.Int
+	head     *types.Header
+	syncing  bool
+	gasPrice *big.Int
+	baseFees []*big.Int
+	rewards  [][]*big.Int
+	accounts []common.Address
+	unlocked []common.Address
+	subs     []testSub
+	// messages is a number of received JSON-RPC messages,
+	// every element of a batch is counted.
+	messages int
+
+	server *httptest.Server
+}
+
+func newTestNode(t *testing.T, chainID int64) *testNode {
+	n := &testNode{
+		chainID: big.NewInt(chainID),
+		head: &types.Header{
+			Number:     big.NewInt(100),
+			Time:       uint64(time.Now().Unix()),
+			Difficulty: big.NewInt(1),
+			BaseFee:    big.NewInt(1e9),
+		},
+		gasPrice: big.NewInt(2e9),
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &testEthService{n}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("personal",
+		&testPersonalService{n}); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := server.WebsocketHandler([]string{"*"})
+
+	n.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") == "websocket" {
+				ws.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			messages := 1
+			var batch []json.RawMessage
+			if json.Unmarshal(body, &batch) == nil {
+				messages = len(batch)
+			}
+
+			n.mtx.Lock()
+			n.messages += messages
+			n.mtx.Unlock()
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			server.ServeHTTP(w, r)
+		}))
+	t.Cleanup(n.server.Close)
+	t.Cleanup(server.Stop)
+
+	return n
+}
+
+func (n *testNode) url() string {
+	return n.server.URL
+}
+
+func (n *testNode) wsURL() string {
+	return "ws" + strings.TrimPrefix(n.server.URL, "http")
+}
+
+type testSub struct {
+	notifier *rpc.Notifier
+	id       rpc.ID
+}
+
+func (n *testNode) subscribers() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	return len(n.subs)
+}
+
+// notify sends the head to the new heads subscribers.
+func (n *testNode) notify(head *types.Header) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	for _, sub := range n.subs {
+		_ = sub.notifier.Notify(sub.id, head)
+	}
+}
+
+func (n *testNode) setHead(number uint64, age time.Duration) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	head := *n.head
+	head.Number = new(big.Int).SetUint64(number)
+	head.Time = uint64(time.Now().Add(-age).Unix())
+	n.head = &head
+}
+
+func (n *testNode) received() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	return n.messages
+}
+
+type testEthService struct {
+	n *testNode
+}
+
+func (s *testEthService) ChainId() *hexutil.Big {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	return (*hexutil.Big)(s.n.chainID)
+}
+
+func (s *testEthService) Syncing() interface{} {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	if !s.n.syncing {
+		return false
+	}
+
+	return map[string]interface{}{
+		"startingBlock": hexutil.Uint64(0),
+		"currentBlock":  hexutil.Uint64(s.n.head.Number.Uint64()),
+		"highestBlock":  hexutil.Uint64(s.n.head.Number.Uint64() + 100),
+	}
+}
+
+func (s *testEthService) GetBlockByNumber(number rpc.BlockNumber,
+	full bool) *types.Header {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	return s.n.head
+}
+
+func (s *testEthService) NewHeads(ctx context.Context) (*rpc.Subscription,
+	error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	sub := notifier.CreateSubscription()
+
+	s.n.mtx.Lock()
+	s.n.subs = append(s.n.subs, testSub{notifier: notifier, id: sub.ID})
+	s.n.mtx.Unlock()
+
+	return sub, nil
+}
+
+func (s *testEthService) GasPrice() *hexutil.Big {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	return (*hexutil.Big)(s.n.gasPrice)
+}
+
+func (s *testEthService) FeeHistory(count hexutil.Uint,
+	last rpc.BlockNumber, percentiles []float64) map[string]interface{} {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	result := map[string]interface{}{
+		"oldestBlock": (*hexutil.Big)(s.n.head.Number),
+	}
+
+	var baseFees []*hexutil.Big
+	for _, fee := range s.n.baseFees {
+		baseFees = append(baseFees, (*hexutil.Big)(fee))
+	}
+	result["baseFeePerGas"] = baseFees
+
+	if len(s.n.rewards) != 0 {
+		var rewards [][]*hexutil.Big
+		for _, block := range s.n.rewards {
+			var row []*hexutil.Big
+			for _, reward := range block {
+				row = append(row, (*hexutil.Big)(reward))
+			}
+			rewards = append(rewards, row)
+		}
+		result["reward"] = rewards
+	}
+
+	return result
+}
+
+type testPersonalService struct {
+	n *testNode
+}
+
+func (s *testPersonalService) ListAccounts() []common.Address {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	return s.n.accounts
+}
+
+func (s *testPersonalService) UnlockAccount(account common.Address,
+	password string, duration *uint64) bool {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	s.n.unlocked = append(s.n.unlocked, account)
+	return true
+}
@@ -0,0 +1,202 @@
+package eth
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+)
+
+// NonceSource returns the next nonce of an account known to the node,
+// including transactions in the mempool.
+type NonceSource interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (
+		tx *types.Transaction, isPending bool, err error)
+}
+
+// NonceManager assigns nonces to outgoing transactions. The next nonce
+// of each sender is stored in the database. Sends from the same sender
+// are serialized with a lock of its database row, so that several
+// replicas can send transactions, sends from different senders
+// run concurrently.
+type NonceManager struct {
+	db     *reform.DB
+	source NonceSource
+}
+
+// NewNonceManager creates a new nonce manager.
+func NewNonceManager(database *reform.DB, source NonceSource) *NonceManager {
+	return &NonceManager{
+		db:     database,
+		source: source,
+	}
+}
+
+type nonceLockKey struct {
+	account common.Address
+}
+
+// Lock locks the nonce of the account for the time of the function.
+// The function runs in the database transaction holding the lock,
+// sends from the account made with the passed context reuse it.
+func (m *NonceManager) Lock(ctx context.Context, account common.Address,
+	fn func(ctx context.Context, q *reform.Querier) error) error {
+	if q, ok := ctx.Value(nonceLockKey{account}).(*reform.Querier); ok {
+		return fn(ctx, q)
+	}
+
+	acc := strings.ToLower(account.String())
+
+	return m.db.InTransaction(func(t *reform.TX) error {
+		// The row is created first, so that it can be locked.
+		if _, err := t.Exec(`INSERT INTO nonces (account, next)
+			VALUES ($1, 0) ON CONFLICT (account) DO NOTHING`,
+			acc); err != nil {
+			return err
+		}
+
+		if err := t.SelectOneTo(&data.Nonce{},
+			"WHERE account = $1 FOR UPDATE", acc); err != nil {
+			return err
+		}
+
+		return fn(context.WithValue(ctx, nonceLockKey{account},
+			t.Querier), t.Querier)
+	})
+}
+
+// LockNonce runs the function holding the nonce lock of the account.
+// Without a nonce manager it runs the function in a database transaction.
+func LockNonce(ctx context.Context, nonces *NonceManager,
+	database *reform.DB, account common.Address,
+	fn func(ctx context.Context, q *reform.Querier) error) error {
+	if nonces != nil {
+		return nonces.Lock(ctx, account, fn)
+	}
+
+	return database.InTransaction(func(t *reform.TX) error {
+		return fn(ctx, t.Querier)
+	})
+}
+
+// next returns the nonce for a new transaction: the stored nonce or the
+// node nonce, whichever is greater. If the stored nonce is ahead of the
+// node and none of the transactions between them is known to the node,
+// they never reached the mempool, so the gap is filled starting from
+// the node nonce.
+func (m *NonceManager) next(ctx context.Context, q *reform.Querier,
+	account common.Address) (uint64, error) {
+	pending, err := m.source.PendingNonceAt(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := &data.Nonce{}
+	if err := q.FindByPrimaryKeyTo(stored,
+		strings.ToLower(account.String())); err != nil {
+		return 0, err
+	}
+
+	if stored.Next <= pending {
+		if stored.Next != 0 && stored.Next < pending {
+			log.Printf("nonce of %s was advanced outside of the"+
+				" application: expected nonce %d, node nonce %d",
+				stored.Account, stored.Next, pending)
+		}
+		return pending, nil
+	}
+
+	known, err := m.knownFrom(ctx, q, stored.Account, pending, stored.Next)
+	if err != nil || known {
+		return stored.Next, err
+	}
+
+	log.Printf("nonce gap detected for %s: expected nonce %d,"+
+		" node nonce %d, filling the gap",
+		stored.Account, stored.Next, pending)
+	return pending, nil
+}
+
+// knownFrom returns true if the node knows a pending outgoing
+// transaction of the account with a nonce in [from, to).
+func (m *NonceManager) knownFrom(ctx context.Context, q *reform.Querier,
+	account string, from, to uint64) (bool, error) {
+	outputs, err := q.SelectAllFrom(data.OutputTable,
+		"WHERE account = $1 AND status = $2", account, data.OutputPending)
+	if err != nil {
+		return false, err
+	}
+
+	for k := range outputs {
+		tx, _, err := m.source.TransactionByHash(ctx,
+			common.HexToHash(outputs[k].(*data.Output).Hash))
+		if err == ethereum.NotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if tx.Nonce() >= from && tx.Nonce() < to {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Send assigns a nonce to a transaction of the account and calls
+// the send function with it. The stored nonce is advanced only if the
+// transaction has been sent successfully.
+func (m *NonceManager) Send(ctx context.Context, account common.Address,
+	send func(nonce uint64) error) error {
+	return m.Lock(ctx, account,
+		func(ctx context.Context, q *reform.Querier) error {
+			nonce, err := m.next(ctx, q, account)
+			if err != nil {
+				return err
+			}
+
+			if err := send(nonce); err != nil {
+				return err
+			}
+
+			return q.Save(&data.Nonce{
+				Account: strings.ToLower(account.String()),
+				Next:    nonce + 1,
+			})
+		})
+}
+
+// Reconcile synchronizes stored nonces of the accounts with the node.
+func (m *NonceManager) Reconcile(ctx context.Context,
+	accounts []string) error {
+	for _, acc := range accounts {
+		account := common.HexToAddress(acc)
+
+		err := m.Lock(ctx, account,
+			func(ctx context.Context, q *reform.Querier) error {
+				nonce, err := m.next(ctx, q, account)
+				if err != nil {
+					return err
+				}
+
+				return q.Save(&data.Nonce{
+					Account: strings.ToLower(account.String()),
+					Next:    nonce,
+				})
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package eth_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/db"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/gen"
+)
+
+type nonceSource struct {
+	pending uint64
+	txs     map[common.Hash]*types.Transaction
+}
+
+func (s *nonceSource) PendingNonceAt(ctx context.Context,
+	account common.Address) (uint64, error) {
+	return s.pending, nil
+}
+
+func (s *nonceSource) TransactionByHash(ctx context.Context,
+	hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := s.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, true, nil
+}
+
+func newDB(t *testing.T) (*reform.DB, sqlmock.Sqlmock) {
+	sqlDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataBase, err := db.NewDB(sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataBase, sqlMock
+}
+
+func TestNonceManagerSend(t *testing.T) {
+	account := common.HexToAddress(
+		"0xe7dc9fe68da458b54f648146a817126053eeef66")
+	columns := []string{"account", "next"}
+
+	// The transaction with nonce 6 has been sent,
+	// but it is not counted by the node yet.
+	sent := types.NewTx(&types.LegacyTx{Nonce: 6, GasPrice: big.NewInt(1)})
+	output := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    sent.Hash().String(),
+		Account: strings.ToLower(account.String()),
+		Status:  data.OutputPending,
+		Kind:    data.OutputWithdrawal,
+	}
+
+	var outputRow []driver.Value
+	for _, v := range output.Values() {
+		outputRow = append(outputRow, v)
+	}
+
+	tests := []struct {
+		name    string
+		pending uint64
+		stored  uint64
+		known   bool
+		exp     uint64
+	}{
+		{"new account", 5, 0, false, 5},
+		{"in sync", 5, 5, false, 5},
+		{"node behind", 5, 8, true, 8},
+		{"gap", 5, 8, false, 5},
+		{"external send", 7, 5, false, 7},
+	}
+
+	for _, test := range tests {
+		dataBase, sqlMock := newDB(t)
+
+		source := &nonceSource{pending: test.pending}
+		if test.known {
+			source.txs = map[common.Hash]*types.Transaction{
+				sent.Hash(): sent,
+			}
+		}
+
+		manager := eth.NewNonceManager(dataBase, source)
+
+		row := func() *sqlmock.Rows {
+			return sqlmock.NewRows(columns).AddRow(
+				strings.ToLower(account.String()), test.stored)
+		}
+
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(`INSERT INTO nonces`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "nonces" (.+) FOR UPDATE`).
+			WillReturnRows(row())
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "nonces"`).
+			WillReturnRows(row())
+		if test.stored > test.pending {
+			sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs"`).
+				WillReturnRows(sqlmock.NewRows(
+					output.Table().Columns()).AddRow(outputRow...))
+		}
+		sqlMock.ExpectExec(`UPDATE "nonces"`).
+			WithArgs(test.exp+1, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		sqlMock.ExpectCommit()
+
+		var got uint64
+		err := manager.Send(context.Background(), account,
+			func(nonce uint64) error {
+				got = nonce
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != test.exp {
+			t.Fatalf("%s: expected nonce %d, got %d",
+				test.name, test.exp, got)
+		}
+
+		if err := sqlMock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s",
+				test.name, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package eth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// NewAccount creates a new account on Geth node
+// protected by the password.
+func (c *GethClient) NewAccount(ctx context.Context,
+	password string) (common.Address, error) {
+	var result common.Address
+	err := c.rpcCli.CallContext(ctx, &result, "personal_newAccount",
+		password)
+	return result, err
+}
+
+// nodeAccounts returns accounts with keys held by Geth node.
+func (c *GethClient) nodeAccounts(
+	ctx context.Context) ([]common.Address, error) {
+	var result []common.Address
+	err := c.rpcCli.CallContext(ctx, &result, "personal_listAccounts")
+	return result, err
+}
+
+// UnlockAccount unlocks an account on Geth node for the duration
+// in seconds. If the duration is nil, Geth default (300 seconds)
+// is used, zero duration unlocks the account until Geth exits.
+func (c *GethClient) UnlockAccount(ctx context.Context,
+	account common.Address, password string, duration *uint64) error {
+	var unlocked bool
+	if err := c.rpcCli.CallContext(ctx, &unlocked,
+		"personal_unlockAccount", account, password, duration); err != nil {
+		return err
+	}
+
+	if !unlocked {
+		return errors.Errorf("failed to unlock account %s",
+			strings.ToLower(account.Hex()))
+	}
+	return nil
+}
+
+// LockAccount locks an account on Geth node.
+func (c *GethClient) LockAccount(ctx context.Context,
+	account common.Address) error {
+	var locked bool
+	if err := c.rpcCli.CallContext(ctx, &locked,
+		"personal_lockAccount", account); err != nil {
+		return err
+	}
+
+	if !locked {
+		return errors.Errorf("failed to lock account %s",
+			strings.ToLower(account.Hex()))
+	}
+	return nil
+}
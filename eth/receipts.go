@@ -0,0 +1,92 @@
+package eth
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+// ErrBlockReceiptsUnsupported is returned when the node
+// does not support eth_getBlockReceipts.
+var ErrBlockReceiptsUnsupported = errors.New(
+	"eth_getBlockReceipts is not supported")
+
+// receiptsBatchSize is a maximum number of receipts in a batch request.
+const receiptsBatchSize = 100
+
+// errCodeMethodNotFound is a JSON-RPC error code of unknown methods.
+const errCodeMethodNotFound = -32601
+
+// BlockReceipts returns receipts of all transactions of a block
+// with eth_getBlockReceipts. The block is requested by its hash, so that
+// receipts of another block at the same height are never returned.
+// If the node does not support the method, ErrBlockReceiptsUnsupported
+// is returned.
+func (c *GethClient) BlockReceipts(ctx context.Context,
+	hash common.Hash) ([]*types.Receipt, error) {
+	if atomic.LoadInt32(&c.noBlockReceipts) != 0 {
+		return nil, ErrBlockReceiptsUnsupported
+	}
+
+	var result []*types.Receipt
+	err := c.rpcCli.CallContext(ctx, &result, "eth_getBlockReceipts",
+		hash)
+	if rpcErr, ok := err.(rpc.Error); ok &&
+		rpcErr.ErrorCode() == errCodeMethodNotFound {
+		atomic.StoreInt32(&c.noBlockReceipts, 1)
+		return nil, ErrBlockReceiptsUnsupported
+	}
+
+	return result, err
+}
+
+// TransactionReceipts returns receipts of transactions. The receipts are
+// fetched in batches with eth_getTransactionReceipt.
+func (c *GethClient) TransactionReceipts(ctx context.Context,
+	hashes []common.Hash) ([]*types.Receipt, error) {
+	result := make([]*types.Receipt, len(hashes))
+
+	for start := 0; start < len(hashes); start += receiptsBatchSize {
+		end := start + receiptsBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		batch := make([]rpc.BatchElem, end-start)
+		for k := range batch {
+			batch[k] = rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{hashes[start+k]},
+				Result: &result[start+k],
+			}
+		}
+
+		if err := c.rpcCli.BatchCallContext(ctx, batch); err != nil {
+			return nil, err
+		}
+
+		for k := range batch {
+			if batch[k].Error != nil {
+				return nil, batch[k].Error
+			}
+
+			if result[start+k] == nil {
+				return nil, errors.Wrapf(ethereum.NotFound,
+					"receipt of transaction %s", hashes[start+k].String())
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// FilterLogs returns logs matching the filter query.
+func (c *GethClient) FilterLogs(ctx context.Context,
+	query ethereum.FilterQuery) ([]types.Log, error) {
+	return c.ethCli.FilterLogs(ctx, query)
+}
@@ -0,0 +1,144 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/gen"
+)
+
+// Errors of transaction replacement.
+var (
+	ErrTxNotPending = errors.New("transaction is not pending")
+	ErrTxMined      = errors.New("transaction has already been mined")
+	ErrTxReplaced   = errors.New("transaction has already been replaced")
+)
+
+// ReplaceTransaction sends a transaction with the nonce of a pending
+// transaction and bumped fees. The speed-up transaction repeats
+// the original one, the cancel transaction is a zero-value transfer
+// to the sender.
+func (c *GethClient) ReplaceTransaction(ctx context.Context,
+	from common.Address, hash common.Hash, cancel bool,
+	caps *FeeCaps) (*string, error) {
+	original, pending, err := c.ethCli.TransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pending {
+		return nil, ErrTxNotPending
+	}
+
+	suggested, err := c.suggestFees(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fees, err := replacementFees(original, suggested,
+		c.cfg.FeeBumpPercent, caps)
+	if err != nil {
+		return nil, err
+	}
+
+	to, amount, gas := original.To(), original.Value(), original.Gas()
+	if cancel {
+		to, amount, gas = &from, new(big.Int), params.TxGas
+	}
+
+	if to == nil {
+		return nil, errors.New("contract creation can not be replaced")
+	}
+
+	nonce := original.Nonce()
+
+	if c.signer != nil && c.signer.Contains(from) {
+		return c.sendRawTransaction(ctx, from, *to, amount, gas,
+			fees, &nonce)
+	}
+	return c.sendGethTransaction(ctx, from, *to, amount, gas, fees, &nonce)
+}
+
+// ReplaceOutput replaces a pending outgoing transaction and stores
+// the replacement output linked to the original one. It holds the nonce
+// lock of the sender, re-reads the original output locked for update and
+// stores the replacement in the same database transaction, so that
+// an output is replaced only once. The nonce manager is optional.
+func ReplaceOutput(ctx context.Context, database *reform.DB,
+	nonces *NonceManager, client Client, hash string, cancel bool,
+	caps *FeeCaps) (*data.Output, error) {
+	hash = strings.ToLower(hash)
+
+	found := &data.Output{}
+	if err := database.FindOneTo(found, "hash", hash); err != nil {
+		return nil, err
+	}
+
+	var replacement *data.Output
+
+	account := common.HexToAddress(found.Account)
+
+	err := LockNonce(ctx, nonces, database, account,
+		func(ctx context.Context, q *reform.Querier) error {
+			original := &data.Output{}
+			if err := q.SelectOneTo(original,
+				"WHERE hash = $1 FOR UPDATE", hash); err != nil {
+				return err
+			}
+
+			if original.MinedHash != nil {
+				return ErrTxMined
+			}
+
+			if original.ReplacedBy != nil {
+				return ErrTxReplaced
+			}
+
+			if original.Status != data.OutputPending {
+				return ErrTxNotPending
+			}
+
+			newHash, err := client.ReplaceTransaction(ctx,
+				common.HexToAddress(original.Account),
+				common.HexToHash(original.Hash), cancel, caps)
+			if err != nil {
+				return err
+			}
+
+			replacement = &data.Output{
+				ID:      gen.NewUUID(),
+				Hash:    strings.ToLower(*newHash),
+				Account: original.Account,
+				SentAt:  pointer.ToUint64(uint64(time.Now().Unix())),
+				Status:  data.OutputPending,
+				Kind:    original.Kind,
+				Amount:  original.Amount,
+				Nonce:   original.Nonce,
+			}
+
+			if cancel {
+				replacement.Amount = pointer.ToString("0")
+			}
+
+			original.ReplacedBy = pointer.ToString(replacement.ID)
+
+			if err := q.Insert(replacement); err != nil {
+				return err
+			}
+			return q.Save(original)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return replacement, nil
+}
@@ -0,0 +1,102 @@
+package eth_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/gen"
+)
+
+func TestReplaceOutputConcurrent(t *testing.T) {
+	ctx := context.Background()
+	client, from := newMockClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	client.AutoMine = false
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataBase, sqlMock := newDB(t)
+	sqlMock.MatchExpectationsInOrder(false)
+
+	output := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    strings.ToLower(*hash),
+		Account: strings.ToLower(from.String()),
+		Status:  data.OutputPending,
+		Kind:    data.OutputWithdrawal,
+		Amount:  pointer.ToString("1000"),
+	}
+
+	// The second replacement reads the output after the first one
+	// has been committed.
+	replaced := *output
+	replaced.ReplacedBy = pointer.ToString(gen.NewUUID())
+
+	row := func(output *data.Output) *sqlmock.Rows {
+		var values []driver.Value
+		for _, v := range output.Values() {
+			values = append(values, v)
+		}
+		return sqlmock.NewRows(output.Table().Columns()).AddRow(values...)
+	}
+
+	for i := 0; i < 2; i++ {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE "outputs"."hash" = \$1`).
+			WithArgs(output.Hash).WillReturnRows(row(output))
+		sqlMock.ExpectBegin()
+	}
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE hash = \$1 FOR UPDATE`).
+		WithArgs(output.Hash).WillReturnRows(row(output))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE hash = \$1 FOR UPDATE`).
+		WithArgs(output.Hash).WillReturnRows(row(&replaced))
+	sqlMock.ExpectQuery(`INSERT INTO "outputs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(gen.NewUUID()))
+	sqlMock.ExpectExec(`UPDATE "outputs"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectRollback()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = eth.ReplaceOutput(ctx, dataBase, nil, client,
+				output.Hash, false, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	var replacedErrs int
+	for _, err := range errs {
+		if errors.Is(err, eth.ErrTxReplaced) {
+			replacedErrs++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if replacedErrs != 1 {
+		t.Fatalf("expected one replacement to fail, got %v", errs)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
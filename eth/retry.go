@@ -0,0 +1,349 @@
+package eth
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+)
+
+// errCodeLimitExceeded is a JSON-RPC error code returned by hosted
+// node providers when the request quota is exceeded.
+const errCodeLimitExceeded = -32005
+
+// sendRequests is a number of requests made to send a transaction:
+// fee suggestion, gas estimation and the transaction itself.
+const sendRequests = 4
+
+// RetryClient is an Ethereum client decorator, which retries transient
+// errors with jittered exponential backoff and limits the rate of
+// requests. Sending transactions is never retried, because a timed out
+// transaction could have been broadcast.
+type RetryClient struct {
+	client  Client
+	cfg     *config.Eth
+	limiter *rate.Limiter
+}
+
+// NewRetryClient creates a new retry client.
+func NewRetryClient(client Client, cfg *config.Eth) *RetryClient {
+	return &RetryClient{
+		client:  client,
+		cfg:     cfg,
+		limiter: newLimiter(cfg.RequestsPerSecond),
+	}
+}
+
+// newLimiter returns a limiter of the rate, which allows to spend
+// the budget of one second at once. Zero rate means no limit.
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	burst := int(math.Ceil(perSecond))
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// wait waits until n requests are allowed by the rate limit.
+// Requests exceeding the burst are waited for in parts.
+func (c *RetryClient) wait(ctx context.Context, n int) error {
+	for n > 0 {
+		part := n
+		if burst := c.limiter.Burst(); c.limiter.Limit() != rate.Inf &&
+			part > burst {
+			part = burst
+		}
+
+		if err := c.limiter.WaitN(ctx, part); err != nil {
+			return err
+		}
+		n -= part
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// transientErrors are errors of the network and of the node load.
+var transientErrors = []error{io.EOF, io.ErrUnexpectedEOF,
+	context.DeadlineExceeded, syscall.ECONNRESET, syscall.ECONNREFUSED,
+	syscall.EPIPE}
+
+// isTransient returns true if a call failed because of the network
+// or the node load, so it is worth retrying.
+func isTransient(err error) bool {
+	for _, target := range transientErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests ||
+			httpErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == errCodeLimitExceeded
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// backoff returns a random delay before the retry with the number,
+// its upper bound grows exponentially.
+func (c *RetryClient) backoff(attempt uint64) time.Duration {
+	limit := time.Duration(c.cfg.RetryMinBackoff) * time.Millisecond
+	max := time.Duration(c.cfg.RetryMaxBackoff) * time.Millisecond
+
+	for i := uint64(0); i < attempt && limit < max; i++ {
+		limit *= 2
+	}
+
+	if limit > max {
+		limit = max
+	}
+
+	if limit <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// do calls the function within the rate limit and retries transient
+// errors. Every attempt waits for the number of requests it makes.
+func (c *RetryClient) do(ctx context.Context, retry bool, requests int,
+	call func() error) error {
+	for attempt := uint64(0); ; attempt++ {
+		if err := c.wait(ctx, requests); err != nil {
+			return err
+		}
+
+		err := call()
+		if err == nil || !retry || !isTransient(err) ||
+			attempt >= c.cfg.RetryAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		if err := sleep(ctx, c.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// Accounts gets accounts with retries.
+func (c *RetryClient) Accounts(ctx context.Context) (result []string,
+	err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.Accounts(ctx)
+		return err
+	})
+	return result, err
+}
+
+// NewAccount creates a new account within the rate limit. It is not
+// retried, because a timed out call could have created the account.
+func (c *RetryClient) NewAccount(ctx context.Context,
+	password string) (result common.Address, err error) {
+	err = c.do(ctx, false, 1, func() error {
+		result, err = c.client.NewAccount(ctx, password)
+		return err
+	})
+	return result, err
+}
+
+// UnlockAccount unlocks an account with retries.
+func (c *RetryClient) UnlockAccount(ctx context.Context,
+	account common.Address, password string, duration *uint64) error {
+	return c.do(ctx, true, 1, func() error {
+		return c.client.UnlockAccount(ctx, account, password, duration)
+	})
+}
+
+// LockAccount locks an account with retries.
+func (c *RetryClient) LockAccount(ctx context.Context,
+	account common.Address) error {
+	return c.do(ctx, true, 1, func() error {
+		return c.client.LockAccount(ctx, account)
+	})
+}
+
+// SendTransaction sends a transaction within the rate limit.
+func (c *RetryClient) SendTransaction(ctx context.Context, from,
+	to common.Address, amount *big.Int, caps *FeeCaps) (result *string,
+	err error) {
+	err = c.do(ctx, false, sendRequests, func() error {
+		result, err = c.client.SendTransaction(ctx, from, to, amount, caps)
+		return err
+	})
+	return result, err
+}
+
+// ReplaceTransaction replaces a pending transaction within the rate limit.
+func (c *RetryClient) ReplaceTransaction(ctx context.Context,
+	from common.Address, hash common.Hash, cancel bool,
+	caps *FeeCaps) (result *string, err error) {
+	err = c.do(ctx, false, sendRequests, func() error {
+		result, err = c.client.ReplaceTransaction(ctx, from, hash,
+			cancel, caps)
+		return err
+	})
+	return result, err
+}
+
+// NetworkID returns the network ID with retries.
+func (c *RetryClient) NetworkID(ctx context.Context) (result *big.Int,
+	err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.NetworkID(ctx)
+		return err
+	})
+	return result, err
+}
+
+// BlockByNumber returns a block with retries.
+func (c *RetryClient) BlockByNumber(ctx context.Context,
+	number *big.Int) (result *types.Block, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.BlockByNumber(ctx, number)
+		return err
+	})
+	return result, err
+}
+
+// TransactionByHash returns a transaction with retries.
+func (c *RetryClient) TransactionByHash(ctx context.Context,
+	hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		tx, isPending, err = c.client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+// TransactionReceipt returns a transaction receipt with retries.
+func (c *RetryClient) TransactionReceipt(ctx context.Context,
+	txHash common.Hash) (result *types.Receipt, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return result, err
+}
+
+// TransactionReceipts returns transaction receipts with retries.
+// Every receipt of the batch counts as a request.
+func (c *RetryClient) TransactionReceipts(ctx context.Context,
+	hashes []common.Hash) (result []*types.Receipt, err error) {
+	err = c.do(ctx, true, len(hashes), func() error {
+		result, err = c.client.TransactionReceipts(ctx, hashes)
+		return err
+	})
+	return result, err
+}
+
+// BlockReceipts returns block receipts with retries.
+func (c *RetryClient) BlockReceipts(ctx context.Context,
+	hash common.Hash) (result []*types.Receipt, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.BlockReceipts(ctx, hash)
+		return err
+	})
+	return result, err
+}
+
+// FilterLogs returns logs with retries.
+func (c *RetryClient) FilterLogs(ctx context.Context,
+	query ethereum.FilterQuery) (result []types.Log, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.FilterLogs(ctx, query)
+		return err
+	})
+	return result, err
+}
+
+// TraceBlock traces a block with retries.
+func (c *RetryClient) TraceBlock(ctx context.Context,
+	number *big.Int) (result []*TxTrace, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.TraceBlock(ctx, number)
+		return err
+	})
+	return result, err
+}
+
+// BalanceAt returns an account balance with retries.
+func (c *RetryClient) BalanceAt(ctx context.Context,
+	account common.Address, blockNumber *big.Int) (result *big.Int,
+	err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+// NonceAt returns the nonce of an account with retries.
+func (c *RetryClient) NonceAt(ctx context.Context,
+	account common.Address, blockNumber *big.Int) (result uint64,
+	err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.NonceAt(ctx, account, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+// SyncProgress returns the sync progress with retries.
+func (c *RetryClient) SyncProgress(
+	ctx context.Context) (result *ethereum.SyncProgress, err error) {
+	err = c.do(ctx, true, 1, func() error {
+		result, err = c.client.SyncProgress(ctx)
+		return err
+	})
+	return result, err
+}
+
+// SubscribeNewHead subscribes to new block headers within the rate
+// limit. A failed subscription is restored by the caller.
+func (c *RetryClient) SubscribeNewHead(ctx context.Context,
+	ch chan<- *types.Header) (result ethereum.Subscription, err error) {
+	err = c.do(ctx, false, 1, func() error {
+		result, err = c.client.SubscribeNewHead(ctx, ch)
+		return err
+	})
+	return result, err
+}
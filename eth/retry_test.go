@@ -0,0 +1,109 @@
+package eth_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+func newRetryConfig() *config.Eth {
+	cfg := config.NewConfig().Eth
+	cfg.RetryAttempts = 2
+	cfg.RetryMinBackoff = 1
+	cfg.RetryMaxBackoff = 5
+	return cfg
+}
+
+func TestRetryClientRetries(t *testing.T) {
+	ctx := context.Background()
+	mock, _ := newMockClient(t)
+	client := eth.NewRetryClient(mock, newRetryConfig())
+
+	tooManyRequests := rpc.HTTPError{StatusCode: 429,
+		Status: "429 Too Many Requests"}
+
+	// Transient errors are retried.
+	mock.FailNext("BlockByNumber", tooManyRequests)
+	mock.FailNext("BlockByNumber", syscall.ECONNRESET)
+
+	if _, err := client.BlockByNumber(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries are limited.
+	for i := 0; i < 3; i++ {
+		mock.FailNext("BlockByNumber", tooManyRequests)
+	}
+
+	if _, err := client.BlockByNumber(ctx, nil); err == nil {
+		t.Fatal("expected error after the last retry")
+	}
+
+	// Other errors are returned at once.
+	errFatal := errors.New("fatal")
+	mock.FailNext("BlockByNumber", errFatal)
+
+	if _, err := client.BlockByNumber(ctx, nil); err != errFatal {
+		t.Fatalf("expected %v, got %v", errFatal, err)
+	}
+
+	// Transactions are never sent twice.
+	mock.FailNext("SendTransaction", tooManyRequests)
+
+	if _, err := client.SendTransaction(ctx, common.Address{},
+		common.Address{}, big.NewInt(1), nil); err == nil {
+		t.Fatal("expected error of the first attempt")
+	}
+}
+
+func TestRetryClientRateLimit(t *testing.T) {
+	ctx := context.Background()
+	mock, from := newMockClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	hash, err := mock.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newRetryConfig()
+	cfg.RequestsPerSecond = 100
+
+	var client eth.Client = eth.NewRetryClient(mock, cfg)
+
+	// Every receipt of a batch is a request, so 150 receipts and
+	// a block exceed the burst of 100 requests by 51.
+	hashes := make([]common.Hash, 150)
+	for k := range hashes {
+		hashes[k] = common.HexToHash(*hash)
+	}
+
+	start := time.Now()
+	if _, err := client.TransactionReceipts(ctx, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.BlockByNumber(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Fatalf("151 requests at 100 per second took %s", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := client.NetworkID(cancelled); err == nil {
+		t.Fatal("expected error of the cancelled context")
+	}
+}
@@ -0,0 +1,72 @@
+package eth
+
+import (
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+)
+
+// Signer signs transactions of locally managed accounts.
+type Signer interface {
+	Accounts() []common.Address
+	Contains(account common.Address) bool
+	SignTx(account common.Address, tx *types.Transaction,
+		chainID *big.Int) (*types.Transaction, error)
+}
+
+// KeystoreSigner is a signer backed by encrypted keystore files
+// in the go-ethereum keystore format.
+type KeystoreSigner struct {
+	ks *keystore.KeyStore
+}
+
+// NewKeystoreSigner creates a new keystore signer and unlocks all
+// accounts from the keystore directory with the password from
+// the password file.
+func NewKeystoreSigner(cfg *config.Eth) (*KeystoreSigner, error) {
+	password, err := os.ReadFile(cfg.KeystorePasswordFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read keystore password")
+	}
+
+	ks := keystore.NewKeyStore(cfg.KeystoreDir,
+		keystore.StandardScryptN, keystore.StandardScryptP)
+
+	pass := strings.TrimRight(string(password), "\r\n")
+
+	for _, acc := range ks.Accounts() {
+		if err := ks.Unlock(acc, pass); err != nil {
+			return nil, errors.Wrapf(err, "failed to unlock account %s",
+				acc.Address.Hex())
+		}
+	}
+
+	return &KeystoreSigner{ks: ks}, nil
+}
+
+// Accounts returns accounts from the keystore.
+func (s *KeystoreSigner) Accounts() (result []common.Address) {
+	for _, acc := range s.ks.Accounts() {
+		result = append(result, acc.Address)
+	}
+	return result
+}
+
+// Contains returns true if the account is in the keystore.
+func (s *KeystoreSigner) Contains(account common.Address) bool {
+	return s.ks.HasAddress(account)
+}
+
+// SignTx signs a transaction with the key of the account.
+func (s *KeystoreSigner) SignTx(account common.Address,
+	tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return s.ks.SignTx(accounts.Account{Address: account}, tx, chainID)
+}
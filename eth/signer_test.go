@@ -0,0 +1,85 @@
+package eth_test
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+func TestKeystoreSigner(t *testing.T) {
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keystore")
+
+	ks := keystore.NewKeyStore(keys, keystore.LightScryptN,
+		keystore.LightScryptP)
+	account, err := ks.NewAccount("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig().Eth
+	cfg.KeystoreDir = keys
+	cfg.KeystorePasswordFile = filepath.Join(dir, "password")
+
+	if err := os.WriteFile(cfg.KeystorePasswordFile, []byte("wrong\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := eth.NewKeystoreSigner(cfg); err == nil {
+		t.Fatal("keystore is unlocked with a wrong password")
+	}
+
+	if err := os.WriteFile(cfg.KeystorePasswordFile, []byte("secret\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := eth.NewKeystoreSigner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !signer.Contains(account.Address) ||
+		len(signer.Accounts()) != 1 {
+		t.Fatalf("account %s not found in %v", account.Address.Hex(),
+			signer.Accounts())
+	}
+
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+	chainID := big.NewInt(5)
+
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     1,
+		GasTipCap: big.NewInt(1e9),
+		GasFeeCap: big.NewInt(2e10),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(1000),
+	})
+
+	signed, err := signer.SignTx(account.Address, tx, chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID),
+		signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sender != account.Address {
+		t.Fatalf("expected sender %s, got %s", account.Address.Hex(),
+			sender.Hex())
+	}
+}
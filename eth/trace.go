@@ -0,0 +1,40 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// CallFrame is a call frame produced by the call tracer.
+type CallFrame struct {
+	Type  string         `json:"type"`
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *hexutil.Big   `json:"value,omitempty"`
+	Error string         `json:"error,omitempty"`
+	Calls []CallFrame    `json:"calls,omitempty"`
+}
+
+// TxTrace is a trace of a transaction. Traces of a block are ordered
+// as the block transactions.
+type TxTrace struct {
+	Result *CallFrame `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+type traceConfig struct {
+	Tracer string `json:"tracer"`
+}
+
+// TraceBlock traces all transactions of a block with the call tracer
+// using debug_traceBlockByNumber. The node must expose the debug API.
+func (c *GethClient) TraceBlock(ctx context.Context,
+	number *big.Int) ([]*TxTrace, error) {
+	var result []*TxTrace
+	err := c.rpcCli.CallContext(ctx, &result, "debug_traceBlockByNumber",
+		hexutil.EncodeBig(number), traceConfig{Tracer: "callTracer"})
+	return result, err
+}
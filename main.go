@@ -40,18 +40,29 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ethClient, err := eth.NewClient(ctx, cfg.Eth.NodeURL)
+	var signer eth.Signer
+	if cfg.Eth.KeystoreDir != "" {
+		ks, err := eth.NewKeystoreSigner(cfg.Eth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signer = ks
+	}
+
+	ethClient, err := eth.NewFailoverClient(ctx, cfg.Eth, signer)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer ethClient.Close()
 
+	client := eth.NewRetryClient(ethClient, cfg.Eth)
+
 	syncPause := time.Duration(cfg.Proc.SyncPause) * time.Millisecond
-	if err := eth.WaitSync(ctx, ethClient, syncPause); err != nil {
+	if err := eth.WaitSync(ctx, client, syncPause); err != nil {
 		log.Fatal(err)
 	}
 
-	netID, err := ethClient.NetworkID(ctx)
+	netID, err := client.NetworkID(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -70,21 +81,49 @@ func main() {
 	}
 	defer db.CloseDB(database)
 
-	scheduler, err := proc.NewScheduler(ctx, netID, cfg, database, ethClient)
+	accounts, err := client.Accounts(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nonces := eth.NewNonceManager(database, ethClient)
+	if err := nonces.Reconcile(ctx, accounts); err != nil {
+		log.Fatal(err)
+	}
+	ethClient.SetNonceManager(nonces)
+
+	scheduler, err := proc.NewScheduler(ctx, netID, cfg, database, client)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer scheduler.Close()
 
+	scheduler.SetNonceManager(nonces)
+
 	if err := scheduler.Start(); err != nil {
 		log.Fatal(err)
 	}
 
-	handler := api.NewHandler(netID, database, ethClient, gen.NewUUID)
+	handler := api.NewHandler(netID, database, client, gen.NewUUID)
+
+	if cfg.Eth.XPub != "" {
+		wallet, err := eth.NewDepositWallet(cfg.Eth.XPub, cfg.Eth.XPubPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler.SetDepositWallet(wallet)
+	}
+
+	handler.SetNonceManager(nonces)
+	handler.SetScheduler(scheduler)
+	handler.SetFinality(scheduler.Finality())
+	handler.SetArchiveNode(cfg.Eth.ArchiveNode)
+
 	srv, err := api.NewServer(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	srv.SetScheduler(scheduler)
 
 	if err := srv.AddHandler(handler); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,91 @@
+package proc
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/gen"
+)
+
+// SaveBalance stores a balance of an account at a block. A stored
+// balance at the same block is overwritten.
+func SaveBalance(q *reform.Querier, balance *data.Balance) error {
+	if balance.ID == "" {
+		balance.ID = gen.NewUUID()
+	}
+
+	_, err := q.Exec(`INSERT INTO balances
+		(id, account, block, timestamp, balance)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account, block) DO UPDATE SET balance = $5`,
+		balance.ID, balance.Account, balance.Block, balance.Timestamp,
+		balance.Balance)
+	return err
+}
+
+// balanceUpdate is a request to update balances of the accounts
+// at the block.
+type balanceUpdate struct {
+	accounts []string
+	block    *big.Int
+}
+
+// updateBalances updates balances of the accounts at the block with
+// the number. Changed balances are added to the balance history under
+// the number and the time of the block.
+func (s *Scheduler) updateBalances(ctx context.Context, accounts []string,
+	number *big.Int) error {
+	block, err := s.eth.BlockByNumber(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	for k := range accounts {
+		balance, err := s.eth.BalanceAt(ctx,
+			common.HexToAddress(accounts[k]), number)
+		if err != nil {
+			return err
+		}
+
+		account := &data.Account{}
+		if err := s.db.FindOneTo(account,
+			"public_key", accounts[k]); err != nil {
+			if err != reform.ErrNoRows {
+				return err
+			}
+
+			account.ID = gen.NewUUID()
+			account.PublicKey = accounts[k]
+			account.Kind = data.AccountNode
+		}
+
+		changed := account.Balance != balance.String()
+		account.Balance = balance.String()
+
+		err = s.inLease(func(q *reform.Querier) error {
+			if err := q.Save(account); err != nil {
+				return err
+			}
+
+			if !changed {
+				return nil
+			}
+
+			return SaveBalance(q, &data.Balance{
+				Account:   account.PublicKey,
+				Block:     block.NumberU64(),
+				Timestamp: block.Time(),
+				Balance:   account.Balance,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
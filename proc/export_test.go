@@ -0,0 +1,76 @@
+package proc
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+// CheckReorg exports checkReorg for tests.
+var CheckReorg = (*Scheduler).checkReorg
+
+// UpdatedAccounts returns accounts queued for a balance update
+// and the block to read the balances at.
+func UpdatedAccounts(s *Scheduler) ([]string, *big.Int) {
+	upd := <-s.updBalCh
+	return upd.accounts, upd.block
+}
+
+// UpdateBalances exports updateBalances for tests.
+var UpdateBalances = (*Scheduler).updateBalances
+
+// CheckOutputs exports checkOutputs for tests.
+var CheckOutputs = (*Scheduler).checkOutputs
+
+// SetLastBlock exports setLastBlock for tests.
+var SetLastBlock = (*Scheduler).setLastBlock
+
+// DeliverEvents exports deliverEvents for tests.
+var DeliverEvents = (*Scheduler).deliverEvents
+
+// SetFees exports setFees for tests.
+var SetFees = setFees
+
+// SweepAccounts exports sweepAccounts for tests.
+var SweepAccounts = (*Scheduler).sweepAccounts
+
+// Lead exports lead for tests.
+var Lead = (*Scheduler).lead
+
+// Collect exports collect for tests.
+var Collect = (*Scheduler).collect
+
+// FollowHeads exports followHeads for tests.
+var FollowHeads = (*Scheduler).followHeads
+
+// GetCandidates exports getCandidates for tests.
+var GetCandidates = getCandidates
+
+// GetTokenTransfers exports getTokenTransfers for tests.
+var GetTokenTransfers = getTokenTransfers
+
+// TransferEventID exports transferEventID for tests.
+var TransferEventID = transferEventID
+
+// BlockReceipts exports blockReceipts for tests.
+var BlockReceipts = (*Scheduler).blockReceipts
+
+// InternalCall is an internal call found by getInternalCalls.
+type InternalCall struct {
+	Path  string
+	Frame *eth.CallFrame
+}
+
+// GetInternalCalls exports getInternalCalls for tests.
+func GetInternalCalls(frames []eth.CallFrame,
+	accounts map[common.Address]struct{}) (result []InternalCall) {
+	for _, call := range getInternalCalls(frames, "", accounts) {
+		result = append(result, InternalCall{
+			Path:  call.path,
+			Frame: call.frame,
+		})
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package proc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+)
+
+// Finality decides how many confirmations make a transfer final.
+type Finality struct {
+	confirmations uint64
+	// tiers are sorted by the amount in descending order,
+	// so that the first matching tier is used.
+	tiers []config.ConfirmationTier
+}
+
+// NewFinality creates finality rules from the processing configuration.
+func NewFinality(cfg *config.Proc) *Finality {
+	tiers := make([]config.ConfirmationTier, len(cfg.ConfirmationTiers))
+	for k, tier := range cfg.ConfirmationTiers {
+		tier.Token = strings.ToLower(tier.Token)
+		if tier.MinAmount == nil {
+			tier.MinAmount = new(big.Int)
+		}
+		tiers[k] = tier
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MinAmount.Cmp(tiers[j].MinAmount) > 0
+	})
+
+	return &Finality{confirmations: cfg.Confirmations, tiers: tiers}
+}
+
+// Required returns the number of confirmations required for a transfer
+// of the token with the amount. The token is empty for ETH.
+func (f *Finality) Required(token, amount string) uint64 {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		return f.Max()
+	}
+
+	token = strings.ToLower(token)
+	for _, tier := range f.tiers {
+		if tier.Token == token && value.Cmp(tier.MinAmount) >= 0 {
+			return tier.Confirmations
+		}
+	}
+	return f.confirmations
+}
+
+// Final returns true if a transfer has enough confirmations.
+func (f *Finality) Final(token, amount string, confirmations uint64) bool {
+	return confirmations >= f.Required(token, amount)
+}
+
+// Max returns the largest number of required confirmations.
+func (f *Finality) Max() uint64 {
+	result := f.confirmations
+	for _, tier := range f.tiers {
+		if tier.Confirmations > result {
+			result = tier.Confirmations
+		}
+	}
+	return result
+}
+
+// NotFinal returns an SQL condition selecting transfers, which are not
+// final, and its arguments. Placeholders are numbered from the first.
+// If tokens is true, the condition is for token transfers.
+func (f *Finality) NotFinal(placeholder func(int) string, first int,
+	tokens bool) (string, []interface{}) {
+	next := func(arg interface{}, args *[]interface{}) string {
+		*args = append(*args, arg)
+		return placeholder(first + len(*args) - 1)
+	}
+
+	var args []interface{}
+	var cases []string
+
+	for _, tier := range f.tiers {
+		if (tier.Token != "") != tokens {
+			continue
+		}
+
+		var cond string
+		if tokens {
+			cond = fmt.Sprintf("token = %s AND ", next(tier.Token, &args))
+		}
+
+		cond += fmt.Sprintf("amount::numeric >= %s::numeric THEN %s",
+			next(tier.MinAmount.String(), &args),
+			next(tier.Confirmations, &args))
+		cases = append(cases, "WHEN "+cond)
+	}
+
+	if len(cases) == 0 {
+		return "confirmations < " + next(f.confirmations, &args), args
+	}
+
+	return fmt.Sprintf("confirmations < CASE %s ELSE %s END",
+		strings.Join(cases, " "), next(f.confirmations, &args)), args
+}
+
+// trackedConfirmations returns the number of confirmations up to which
+// transfers and outputs are updated.
+func (s *Scheduler) trackedConfirmations() uint64 {
+	result := s.cfg.Proc.TrackConfirmations
+	if max := s.finality.Max(); max > result {
+		result = max
+	}
+
+	if len(s.cfg.Webhook.URLs) == 0 {
+		return result
+	}
+
+	for _, threshold := range s.cfg.Webhook.Confirmations {
+		if threshold > result {
+			result = threshold
+		}
+	}
+	return result
+}
+
+// Finality returns the finality rules of the scheduler.
+func (s *Scheduler) Finality() *Finality {
+	return s.finality
+}
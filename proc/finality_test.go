@@ -0,0 +1,59 @@
+package proc_test
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/proc"
+)
+
+func TestFinality(t *testing.T) {
+	const token = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	cfg := config.NewConfig().Proc
+	cfg.ConfirmationTiers = []config.ConfirmationTier{
+		{MinAmount: big.NewInt(1e18), Confirmations: 12},
+		{MinAmount: new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18)),
+			Confirmations: 30},
+		{Token: "0xDAC17F958D2EE523A2206206994597C13D831EC7",
+			MinAmount: big.NewInt(1e9), Confirmations: 20},
+	}
+
+	finality := proc.NewFinality(cfg)
+
+	for _, c := range []struct {
+		token  string
+		amount string
+		exp    uint64
+	}{
+		{"", "1000", 3},
+		{"", "1000000000000000000", 12},
+		{"", "20000000000000000000", 30},
+		{token, "1000000000000000000", 20},
+		{token, "1000", 3},
+		{"0xa7dba6053a0d631177340e8061bc12f5009ba453", "1000000000000", 3},
+	} {
+		if got := finality.Required(c.token, c.amount); got != c.exp {
+			t.Errorf("expected %d confirmations of %s %s, got %d",
+				c.exp, c.amount, c.token, got)
+		}
+	}
+
+	if finality.Max() != 30 {
+		t.Fatalf("expected max 30, got %d", finality.Max())
+	}
+
+	placeholder := func(n int) string { return fmt.Sprintf("$%d", n) }
+
+	cond, args := finality.NotFinal(placeholder, 1, true)
+	expCond := "confirmations < CASE WHEN token = $1 AND" +
+		" amount::numeric >= $2::numeric THEN $3 ELSE $4 END"
+	expArgs := []interface{}{token, "1000000000", uint64(20), uint64(3)}
+
+	if cond != expCond || !reflect.DeepEqual(args, expArgs) {
+		t.Fatalf("unexpected condition: %s %v", cond, args)
+	}
+}
@@ -0,0 +1,113 @@
+package proc
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/AlekSi/pointer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+// Call types which transfer value.
+var valueCallTypes = map[string]bool{
+	"CALL":         true,
+	"CALLCODE":     true,
+	"CREATE":       true,
+	"CREATE2":      true,
+	"SELFDESTRUCT": true,
+}
+
+// internalTransactions traces the block and returns value-bearing
+// internal calls to or from the accounts as transactions,
+// and the accounts they touch.
+func (s *Scheduler) internalTransactions(ctx context.Context,
+	block *types.Block,
+	accounts map[common.Address]struct{},
+	confirmations uint64) ([]*data.Transaction, []string, error) {
+	txs := block.Transactions()
+	if len(txs) == 0 {
+		return nil, nil, nil
+	}
+
+	traces, err := s.eth.TraceBlock(ctx, block.Number())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(traces) != len(txs) {
+		return nil, nil, errors.Errorf("block %d has %d transactions,"+
+			" but %d traces", block.NumberU64(), len(txs), len(traces))
+	}
+
+	var result []*data.Transaction
+	var touched []string
+
+	for k, trace := range traces {
+		if trace.Error != "" {
+			return nil, nil, errors.Errorf("failed to trace transaction"+
+				" %s: %s", txs[k].Hash().String(), trace.Error)
+		}
+
+		// Failed transactions do not transfer value.
+		if trace.Result == nil || trace.Result.Error != "" {
+			continue
+		}
+
+		for _, call := range getInternalCalls(trace.Result.Calls, "",
+			accounts) {
+			tx := fillTransaction(txs[k].Hash(), call.frame.From,
+				call.frame.To, call.frame.Value.ToInt(), block.Number(),
+				block.Time(), confirmations)
+			tx.Status = pointer.ToString(data.TxSuccessful)
+			tx.TracePath = pointer.ToString(call.path)
+
+			result = append(result, tx)
+			touched = append(touched, getTargetAccounts(accounts,
+				call.frame.From, call.frame.To)...)
+		}
+	}
+
+	return result, touched, nil
+}
+
+type internalCall struct {
+	frame *eth.CallFrame
+	path  string
+}
+
+// getInternalCalls walks the call tree and returns successful
+// value-bearing calls to or from the accounts.
+func getInternalCalls(frames []eth.CallFrame, parent string,
+	accounts map[common.Address]struct{}) (result []internalCall) {
+	for k := range frames {
+		frame := &frames[k]
+
+		// Reverted calls and their subcalls do not transfer value.
+		if frame.Error != "" {
+			continue
+		}
+
+		path := strconv.Itoa(k)
+		if parent != "" {
+			path = strings.Join([]string{parent, path}, ".")
+		}
+
+		if valueCallTypes[frame.Type] && frame.Value != nil &&
+			frame.Value.ToInt().Cmp(big.NewInt(0)) > 0 &&
+			len(getTargetAccounts(accounts, frame.From, frame.To)) != 0 {
+			result = append(result, internalCall{frame: frame, path: path})
+		}
+
+		result = append(result, getInternalCalls(frame.Calls, path,
+			accounts)...)
+	}
+
+	return result
+}
@@ -0,0 +1,70 @@
+package proc_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/proc"
+)
+
+func TestGetInternalCalls(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/call_trace.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var traces []*eth.TxTrace
+	if err := json.Unmarshal(fixture, &traces); err != nil {
+		t.Fatal(err)
+	}
+
+	contract := common.HexToAddress(
+		"0xc0ffee254729296a45a3885639ac7e10f9d54979")
+	router := common.HexToAddress(
+		"0x7a250d5630b4cf539739df2c5dacb4c659f2488d")
+	acc1 := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	acc2 := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	accounts := map[common.Address]struct{}{acc1: {}, acc2: {}}
+
+	// Reverted calls with their subcalls, calls without value
+	// and delegate calls are skipped.
+	exp := []struct {
+		path     string
+		from, to common.Address
+		value    int64
+	}{
+		{"0", contract, acc1, 0x10},
+		{"1.0", router, acc2, 0x5},
+		{"3.0", contract, acc2, 0x3},
+		{"5", contract, acc1, 0x9},
+	}
+
+	calls := proc.GetInternalCalls(traces[0].Result.Calls, accounts)
+	if len(calls) != len(exp) {
+		t.Fatalf("expected %d internal calls, got %d: %+v",
+			len(exp), len(calls), calls)
+	}
+
+	for k, e := range exp {
+		call := calls[k]
+		if call.Path != e.path || call.Frame.From != e.from ||
+			call.Frame.To != e.to ||
+			call.Frame.Value.ToInt().Int64() != e.value {
+			t.Errorf("expected call %s from %s to %s with value %d,"+
+				" got %s from %s to %s with value %s", e.path,
+				e.from.String(), e.to.String(), e.value, call.Path,
+				call.Frame.From.String(), call.Frame.To.String(),
+				call.Frame.Value.ToInt())
+		}
+	}
+
+	if calls := proc.GetInternalCalls(traces[0].Result.Calls,
+		nil); len(calls) != 0 {
+		t.Fatalf("expected no calls without accounts, got %+v", calls)
+	}
+}
@@ -0,0 +1,170 @@
+package proc
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"gopkg.in/reform.v1"
+)
+
+// leaderSetting is a key of the leader lease in settings. The value is
+// the ID of the leader and the expiration time of the lease in Unix
+// milliseconds. The database clock is used, so that clocks of replicas
+// do not matter.
+const leaderSetting = "leader"
+
+// takeLeaseQuery takes the lease if it is free or expired
+// and renews it if it is held by the replica.
+const takeLeaseQuery = `INSERT INTO settings AS s (key, value)
+	VALUES ($1, $2::text || ' ' ||
+		floor(extract(epoch FROM now()) * 1000 + $3)::bigint)
+	ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value
+	WHERE split_part(s.value, ' ', 1) = $2::text
+		OR split_part(s.value, ' ', 2)::bigint <
+			extract(epoch FROM now()) * 1000`
+
+// releaseLeaseQuery releases the lease if it is held by the replica.
+const releaseLeaseQuery = `DELETE FROM settings
+	WHERE key = $1 AND split_part(value, ' ', 1) = $2`
+
+// checkLeaseQuery locks the lease if it is held by the replica and is not
+// expired, so that it cannot be taken over until the transaction ends.
+const checkLeaseQuery = `SELECT 1 FROM settings
+	WHERE key = $1 AND split_part(value, ' ', 1) = $2
+		AND split_part(value, ' ', 2)::bigint >
+			extract(epoch FROM clock_timestamp()) * 1000
+	FOR SHARE`
+
+// checkLease returns ErrNotLeader if the replica does not hold the leader
+// lease. It fences the writes of the database transaction: a replica,
+// which has lost the lease while the task was running, does not commit
+// them, and the lease is not taken over until they are committed.
+func (s *Scheduler) checkLease(q *reform.Querier) error {
+	if !s.cfg.Proc.LeaderElection {
+		return nil
+	}
+
+	var held int
+	err := q.QueryRow(checkLeaseQuery, leaderSetting,
+		s.leaderID).Scan(&held)
+	if err == sql.ErrNoRows {
+		return ErrNotLeader
+	}
+	return err
+}
+
+// inLease runs the function in a database transaction
+// fenced by the leader lease.
+func (s *Scheduler) inLease(fn func(q *reform.Querier) error) error {
+	return s.db.InTransaction(func(t *reform.TX) error {
+		if err := s.checkLease(t.Querier); err != nil {
+			return err
+		}
+		return fn(t.Querier)
+	})
+}
+
+// elect takes and renews the leader lease until the scheduler is closed.
+// The leader runs the tasks, the other replicas wait for the lease.
+func (s *Scheduler) elect() {
+	defer s.wg.Done()
+
+	tic := time.NewTicker(millis(s.cfg.Proc.LeaderRenewPause))
+	defer tic.Stop()
+
+	for {
+		if err := s.lead(s.ctx); err != nil && s.ctx.Err() == nil {
+			log.Printf("failed to take leader lease: %s", err)
+		}
+
+		select {
+		case <-tic.C:
+		case <-s.ctx.Done():
+			s.resign()
+			return
+		}
+	}
+}
+
+// lead takes or renews the leader lease and starts or stops the tasks.
+// If the lease cannot be renewed in time, the tasks are stopped before
+// the lease expires and another replica can take it.
+func (s *Scheduler) lead(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, millis(
+		s.cfg.Proc.LeaderLease-s.cfg.Proc.LeaderRenewPause))
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, takeLeaseQuery, leaderSetting,
+		s.leaderID, s.cfg.Proc.LeaderLease)
+	if err != nil {
+		s.setLeading(false)
+		return err
+	}
+
+	taken, err := result.RowsAffected()
+	if err != nil {
+		s.setLeading(false)
+		return err
+	}
+
+	s.setLeading(taken == 1)
+	return nil
+}
+
+// resign stops the tasks and releases the lease, so that another replica
+// does not wait for its expiration.
+func (s *Scheduler) resign() {
+	s.setLeading(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		millis(s.cfg.Proc.LeaderRenewPause))
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, releaseLeaseQuery, leaderSetting,
+		s.leaderID); err != nil {
+		log.Printf("failed to release leader lease: %s", err)
+	}
+}
+
+// setLeading starts the tasks when the replica becomes the leader
+// and stops them when it loses the lease.
+func (s *Scheduler) setLeading(leading bool) {
+	s.tasksMtx.Lock()
+	if s.leading == leading {
+		s.tasksMtx.Unlock()
+		return
+	}
+	s.leading = leading
+
+	if leading {
+		log.Printf("replica %s is the leader", s.leaderID)
+		if s.started {
+			s.startTasks()
+		}
+		s.tasksMtx.Unlock()
+		return
+	}
+
+	log.Printf("replica %s is not the leader anymore", s.leaderID)
+
+	var running []*taskEntry
+	for _, name := range s.taskNames {
+		if entry := s.tasks[name]; entry.cancel != nil {
+			running = append(running, entry)
+		}
+	}
+	s.tasksMtx.Unlock()
+
+	s.stopTasks(running)
+}
+
+// Leader returns true if the replica runs the tasks: it is the leader
+// or leader election is disabled.
+func (s *Scheduler) Leader() bool {
+	s.tasksMtx.Lock()
+	defer s.tasksMtx.Unlock()
+
+	return s.leading
+}
@@ -0,0 +1,256 @@
+package proc_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/gen"
+	"github.com/dzeckelev/geth-wrapper/proc"
+)
+
+func TestScheduler_Lead(t *testing.T) {
+	dataBase, mock := newDB(t)
+	client, _ := newEthClient(t)
+
+	cfg := config.NewConfig()
+	cfg.Proc.LeaderElection = true
+
+	ctx := context.Background()
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Close()
+
+	const name = "test"
+	task := proc.NewTask(name, time.Millisecond,
+		func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+
+	if err := scheduler.Register(task); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheduler.StartTask(name); !errors.Is(err, proc.ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", proc.ErrNotLeader, err)
+	}
+
+	lease := mock.ExpectExec("INSERT INTO settings").WithArgs("leader",
+		sqlmock.AnyArg(), cfg.Proc.LeaderLease)
+
+	// The lease is held by another replica.
+	lease.WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := proc.Lead(scheduler, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if scheduler.Leader() {
+		t.Fatal("replica is the leader while the lease is taken")
+	}
+
+	mock.ExpectExec("INSERT INTO settings").WillReturnResult(
+		sqlmock.NewResult(0, 1))
+	if err := proc.Lead(scheduler, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !scheduler.Leader() {
+		t.Fatal("replica is not the leader after taking the lease")
+	}
+
+	if err := scheduler.StartTask(name); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease cannot be renewed, the tasks must be stopped.
+	errDB := errors.New("database is down")
+	mock.ExpectExec("INSERT INTO settings").WillReturnError(errDB)
+	if err := proc.Lead(scheduler, ctx); err != errDB {
+		t.Fatalf("expected %v, got %v", errDB, err)
+	}
+
+	if scheduler.Leader() {
+		t.Fatal("replica is the leader after a failed renewal")
+	}
+
+	if status := taskStatus(t, scheduler, name); status.Running {
+		t.Fatal("task is running after the loss of the lease")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduler_CollectFencing(t *testing.T) {
+	ctx := context.Background()
+	dataBase, mock := newDB(t)
+	client, _ := newEthClient(t)
+	client.Mine()
+
+	cfg := config.NewConfig()
+	cfg.Proc.LeaderElection = true
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proc.SetLastBlock(scheduler, big.NewInt(1))
+
+	mock.ExpectQuery(`SELECT (.+) FROM "settings"`).WillReturnRows(
+		sqlmock.NewRows([]string{"key", "value"}).AddRow("lastBlock", "1"))
+	mock.ExpectQuery(`SELECT (.+) FROM "accounts"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT (.+) FROM "blocks"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(blockColumns))
+
+	// The lease has been taken by another replica while the block
+	// was processed, so the block is not stored.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT 1 FROM settings (.+) FOR SHARE`).
+		WithArgs("leader", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	mock.ExpectRollback()
+
+	if err := proc.Collect(scheduler, ctx); !errors.Is(err,
+		proc.ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", proc.ErrNotLeader, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectLease expects the check of the leader lease, which is lost
+// if held is false.
+func expectLease(mock sqlmock.Sqlmock, held bool) {
+	rows := sqlmock.NewRows([]string{"?column?"})
+	if held {
+		rows.AddRow(1)
+	}
+	mock.ExpectQuery(`SELECT 1 FROM settings (.+) FOR SHARE`).
+		WithArgs("leader", sqlmock.AnyArg()).WillReturnRows(rows)
+}
+
+func TestScheduler_SweepFencing(t *testing.T) {
+	ctx := context.Background()
+	dataBase, mock := newDB(t)
+	client, from := newEthClient(t)
+	cold := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	cfg := config.NewConfig()
+	cfg.Proc.LeaderElection = true
+	cfg.Sweep.ColdAddress = cold.String()
+	cfg.Sweep.MaxFeePerGas = big.NewInt(1e10)
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "transactions"`).
+		WithArgs(strings.ToLower(from.String()), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	// The lease has been lost, so the sweep is not sent.
+	mock.ExpectBegin()
+	expectLease(mock, false)
+	mock.ExpectRollback()
+
+	if err := proc.SweepAccounts(scheduler, ctx); !errors.Is(err,
+		proc.ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", proc.ErrNotLeader, err)
+	}
+
+	balance, err := client.BalanceAt(ctx, cold, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Sign() != 0 {
+		t.Fatalf("expected no sweep, got %s", balance)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduler_SpeedUpFencing(t *testing.T) {
+	ctx := context.Background()
+	dataBase, mock := newDB(t)
+	client, from := newEthClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	client.AutoMine = false
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Proc.LeaderElection = true
+	cfg.Proc.SpeedUpAfter = 60
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    *hash,
+		Account: strings.ToLower(from.String()),
+		SentAt:  pointer.ToUint64(uint64(time.Now().Unix()) - 3600),
+		Status:  data.OutputPending,
+		Kind:    data.OutputWithdrawal,
+	}
+
+	mock.ExpectQuery(`SELECT (.+) FROM "outputs"`).WillReturnRows(
+		sqlmock.NewRows(output.Table().Columns()).
+			AddRow(outputRow(output)...))
+	mock.ExpectBegin()
+	expectLease(mock, true)
+	mock.ExpectExec(`UPDATE "outputs"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// The lease has been lost, so the replacement is not sent.
+	mock.ExpectBegin()
+	expectLease(mock, false)
+	mock.ExpectRollback()
+
+	if err := proc.CheckOutputs(scheduler, ctx); !errors.Is(err,
+		proc.ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", proc.ErrNotLeader, err)
+	}
+
+	if _, pending, err := client.TransactionByHash(ctx,
+		common.HexToHash(*hash)); err != nil || !pending {
+		t.Fatalf("expected the original transaction to be pending,"+
+			" got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
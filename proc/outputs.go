@@ -0,0 +1,360 @@
+package proc
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/eth"
+)
+
+// checkOutputs updates pending and dropped outputs from receipts, marks
+// outputs missing from the mempool as dropped, speeds up outputs which
+// are pending for too long and updates confirmations of mined outputs.
+// The outputs are processed in the order they were sent. A dropped
+// transaction can still be mined, so dropped outputs are checked too.
+func (s *Scheduler) checkOutputs(ctx context.Context) error {
+	items, err := s.db.SelectAllFrom(data.OutputTable,
+		"WHERE status IN ($1, $2) ORDER BY sent_at, id",
+		data.OutputPending, data.OutputDropped)
+	if err != nil {
+		return err
+	}
+
+	outputs := make(map[string]*data.Output)
+	for k := range items {
+		output := items[k].(*data.Output)
+		outputs[output.ID] = output
+	}
+
+	for k := range items {
+		output := items[k].(*data.Output)
+
+		// It has been updated with a mined output of the same nonce.
+		if output.Status != data.OutputPending &&
+			output.Status != data.OutputDropped {
+			continue
+		}
+
+		receipt, err := s.eth.TransactionReceipt(ctx,
+			common.HexToHash(output.Hash))
+		if err == nil {
+			if err := s.setMined(ctx, outputs, output,
+				receipt); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err != ethereum.NotFound {
+			return err
+		}
+
+		if output.Status == data.OutputDropped {
+			continue
+		}
+
+		if err := s.checkPending(ctx, output); err != nil {
+			return err
+		}
+
+		if output.Status == data.OutputPending && s.needSpeedUp(output) {
+			replacement, err := s.speedUp(ctx, output)
+			if err == ErrNotLeader {
+				return err
+			}
+			if err != nil {
+				log.Printf("failed to speed up transaction %s: %s",
+					output.Hash, err)
+				continue
+			}
+
+			log.Printf("transaction %s has been sped up by %s",
+				output.Hash, replacement.Hash)
+		}
+	}
+
+	return s.updateOutputConfirmations()
+}
+
+// speedUp replaces the output with a transaction with bumped fees.
+// The lease is checked before the broadcast and held until
+// the replacement is stored.
+func (s *Scheduler) speedUp(ctx context.Context,
+	output *data.Output) (replacement *data.Output, err error) {
+	err = s.lockNonce(ctx, common.HexToAddress(output.Account),
+		func(ctx context.Context, q *reform.Querier) error {
+			if err := s.checkLease(q); err != nil {
+				return err
+			}
+
+			replacement, err = eth.ReplaceOutput(ctx, s.db, s.nonces,
+				s.eth, output.Hash, false, s.speedUpCaps)
+			return err
+		})
+	return replacement, err
+}
+
+// checkPending updates the time when a pending output was last seen
+// in the mempool and marks it as dropped if it is missing for too long.
+func (s *Scheduler) checkPending(ctx context.Context,
+	output *data.Output) error {
+	tx, pending, err := s.eth.TransactionByHash(ctx,
+		common.HexToHash(output.Hash))
+	if err != nil && err != ethereum.NotFound {
+		return err
+	}
+
+	now := uint64(time.Now().Unix())
+
+	if err == nil {
+		if !pending {
+			// It has been mined after the receipt request.
+			return nil
+		}
+		output.Nonce = pointer.ToUint64(tx.Nonce())
+		output.LastSeenAt = pointer.ToUint64(now)
+		return s.inLease(func(q *reform.Querier) error {
+			return q.Save(output)
+		})
+	}
+
+	// A replaced transaction leaves the mempool.
+	if output.ReplacedBy != nil {
+		return nil
+	}
+
+	lastSeen := output.LastSeenAt
+	if lastSeen == nil {
+		lastSeen = output.SentAt
+	}
+
+	if lastSeen == nil || now < *lastSeen+s.cfg.Proc.DropOutputAfter {
+		return nil
+	}
+
+	output.Status = data.OutputDropped
+	err = s.inLease(func(q *reform.Querier) error {
+		if err := q.Save(output); err != nil {
+			return err
+		}
+		return s.withdrawalEvent(q, output)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("transaction %s has been dropped", output.Hash)
+	return nil
+}
+
+// updateOutputConfirmations updates confirmations of mined outputs.
+func (s *Scheduler) updateOutputConfirmations() error {
+	items, err := s.db.SelectAllFrom(data.OutputTable,
+		"WHERE status IN ($1, $2) AND confirmations <= $3",
+		data.OutputSuccessful, data.OutputFailed,
+		s.trackedConfirmations())
+	if err != nil {
+		return err
+	}
+
+	s.mtx.RLock()
+	lastBlock := s.lastBlockNum.Uint64()
+	s.mtx.RUnlock()
+
+	var updated []*data.Output
+	for k := range items {
+		output := items[k].(*data.Output)
+
+		if output.Block == nil || lastBlock <= *output.Block ||
+			lastBlock-*output.Block <= output.Confirmations {
+			continue
+		}
+
+		output.Confirmations = lastBlock - *output.Block
+		updated = append(updated, output)
+	}
+
+	if len(updated) == 0 {
+		return nil
+	}
+
+	return s.inLease(func(q *reform.Querier) error {
+		for _, output := range updated {
+			if err := q.Save(output); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Scheduler) needSpeedUp(output *data.Output) bool {
+	if s.cfg.Proc.SpeedUpAfter == 0 || output.ReplacedBy != nil ||
+		output.SentAt == nil {
+		return false
+	}
+
+	sentAt := time.Unix(int64(*output.SentAt), 0)
+	return time.Since(sentAt) >
+		time.Duration(s.cfg.Proc.SpeedUpAfter)*time.Second
+}
+
+// setMined stores the receipt data to the mined output and marks
+// all outputs replaced by it or replacing it as replaced. A dropped
+// output becomes mined too.
+func (s *Scheduler) setMined(ctx context.Context,
+	outputs map[string]*data.Output, mined *data.Output,
+	receipt *types.Receipt) error {
+	block, err := s.eth.BlockByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		return err
+	}
+
+	replaced := make(map[string]*data.Output)
+	for _, output := range outputs {
+		if output.ReplacedBy != nil {
+			replaced[*output.ReplacedBy] = output
+		}
+	}
+
+	var group []*data.Output
+	for output := mined; output != nil; output = replaced[output.ID] {
+		group = append(group, output)
+	}
+
+	for output := mined; output.ReplacedBy != nil; {
+		next, ok := outputs[*output.ReplacedBy]
+		if !ok {
+			break
+		}
+		group = append(group, next)
+		output = next
+	}
+
+	mined.Status = data.OutputFailed
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		mined.Status = data.OutputSuccessful
+	}
+
+	mined.Block = pointer.ToUint64(block.NumberU64())
+	mined.BlockHash = pointer.ToString(block.Hash().String())
+	mined.GasUsed = pointer.ToUint64(receipt.GasUsed)
+	mined.MinedAt = pointer.ToUint64(block.Time())
+
+	if tx := block.Transaction(receipt.TxHash); tx != nil {
+		mined.Nonce = pointer.ToUint64(tx.Nonce())
+		mined.EffectiveGasPrice = pointer.ToString(
+			effectiveGasPrice(tx, block.BaseFee()).String())
+	}
+
+	s.mtx.RLock()
+	if s.lastBlockNum.Cmp(block.Number()) > 0 {
+		mined.Confirmations = new(big.Int).Sub(s.lastBlockNum,
+			block.Number()).Uint64()
+	}
+	s.mtx.RUnlock()
+
+	err = s.inLease(func(q *reform.Querier) error {
+		for _, output := range group {
+			output.MinedHash = pointer.ToString(mined.Hash)
+			if output != mined {
+				output.Status = data.OutputReplaced
+			}
+			if err := q.Save(output); err != nil {
+				return err
+			}
+		}
+		return s.withdrawalEvent(q, mined)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(group) > 1 {
+		log.Printf("transaction %s has been mined, replaced outputs: %d",
+			mined.Hash, len(group)-1)
+	}
+
+	return nil
+}
+
+// effectiveGasPrice returns the price paid per gas. For legacy
+// transactions it is the gas price, for EIP-1559 transactions it is
+// the base fee plus the tip limited by the fee cap.
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return tx.GasPrice()
+	}
+
+	price := new(big.Int).Add(baseFee, tx.GasTipCap())
+	if price.Cmp(tx.GasFeeCap()) > 0 {
+		price.Set(tx.GasFeeCap())
+	}
+	return price
+}
+
+// rollbackOutputs returns outputs mined above the common ancestor and
+// outputs replaced by them to pending and queues withdrawal.removed
+// events. It returns the number of rolled back outputs.
+func (s *Scheduler) rollbackOutputs(q *reform.Querier,
+	ancestor uint64) (int, error) {
+	items, err := q.SelectAllFrom(data.OutputTable,
+		"WHERE block > $1", ancestor)
+	if err != nil {
+		return 0, err
+	}
+
+	now := uint64(time.Now().Unix())
+
+	for k := range items {
+		mined := items[k].(*data.Output)
+
+		if err := s.enqueueEvent(q, EventWithdrawalRemoved,
+			withdrawalEventKey(EventWithdrawalRemoved, mined),
+			mined); err != nil {
+			return 0, err
+		}
+
+		replaced, err := q.SelectAllFrom(data.OutputTable,
+			"WHERE mined_hash = $1 AND id <> $2", mined.Hash, mined.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		for j := range replaced {
+			output := replaced[j].(*data.Output)
+			output.Status = data.OutputPending
+			output.MinedHash = nil
+			output.LastSeenAt = pointer.ToUint64(now)
+			if err := q.Save(output); err != nil {
+				return 0, err
+			}
+		}
+
+		mined.Status = data.OutputPending
+		mined.MinedHash = nil
+		mined.Block = nil
+		mined.BlockHash = nil
+		mined.GasUsed = nil
+		mined.EffectiveGasPrice = nil
+		mined.Confirmations = 0
+		mined.MinedAt = nil
+		// The transaction returns to the mempool,
+		// so it is not dropped at once.
+		mined.LastSeenAt = pointer.ToUint64(now)
+		if err := q.Save(mined); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(items), nil
+}
@@ -4,15 +4,20 @@ import (
 	"context"
 	"log"
 	"math/big"
-	"runtime"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/AlekSi/pointer"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
 	"gopkg.in/reform.v1"
 
 	"github.com/dzeckelev/geth-wrapper/config"
@@ -27,35 +32,136 @@ type Scheduler struct {
 	cfg      *config.Config
 	cancel   context.CancelFunc
 	ctx      context.Context
-	eth      *eth.GethClient
+	eth      eth.Client
 	db       *reform.DB
-	updBalCh chan []string
+	nonces   *eth.NonceManager
+	updBalCh chan balanceUpdate
+
+	speedUpCaps   *eth.FeeCaps
+	webhookClient *http.Client
+	finality      *Finality
 
 	mtx          sync.RWMutex
 	lastBlockNum *big.Int
+	newHeadCh    chan struct{}
+
+	errMtx   sync.Mutex
+	taskErrs map[string]TaskError
+
+	tasksMtx  sync.Mutex
+	tasks     map[string]*taskEntry
+	taskNames []string
+	started   bool
+	// leading is true if this replica runs the tasks: it is the leader
+	// or leader election is disabled.
+	leading  bool
+	leaderID string
 
 	wg sync.WaitGroup
 }
 
-type result struct {
-	tx  *data.Transaction
-	acc []string
+// transferEventID is an identifier of ERC-20 Transfer event.
+var transferEventID = crypto.Keccak256Hash(
+	[]byte("Transfer(address,address,uint256)"))
+
+type candidate struct {
+	tx   *types.Transaction
+	from common.Address
 }
 
 // NewScheduler creates a new task scheduler.
 func NewScheduler(ctx context.Context, networkID *big.Int, cfg *config.Config,
-	database *reform.DB, ethClient *eth.GethClient) (*Scheduler, error) {
+	database *reform.DB, ethClient eth.Client) (*Scheduler, error) {
+	speedUpCaps := &eth.FeeCaps{
+		MaxFeePerGas: cfg.Proc.SpeedUpMaxFeePerGas,
+	}
+
+	if err := checkSweepConfig(cfg.Sweep); err != nil {
+		return nil, err
+	}
+
+	if err := checkWebhookConfig(cfg.Webhook); err != nil {
+		return nil, err
+	}
+
+	if cfg.Proc.LeaderElection &&
+		cfg.Proc.LeaderRenewPause >= cfg.Proc.LeaderLease {
+		return nil, errors.New(
+			"LeaderRenewPause must be less than LeaderLease")
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
-	return &Scheduler{
-		netID:    networkID,
-		cfg:      cfg,
-		cancel:   cancel,
-		ctx:      ctx,
-		db:       database,
-		eth:      ethClient,
-		updBalCh: make(chan []string, 1000),
-	}, nil
+	s := &Scheduler{
+		netID:       networkID,
+		cfg:         cfg,
+		cancel:      cancel,
+		ctx:         ctx,
+		db:          database,
+		eth:         ethClient,
+		updBalCh:    make(chan balanceUpdate, 1000),
+		newHeadCh:   make(chan struct{}, 1),
+		speedUpCaps: speedUpCaps,
+		finality:    NewFinality(cfg.Proc),
+		webhookClient: &http.Client{
+			Timeout: time.Millisecond *
+				time.Duration(cfg.Webhook.Timeout),
+		},
+		taskErrs: make(map[string]TaskError),
+		tasks:    make(map[string]*taskEntry),
+		leading:  !cfg.Proc.LeaderElection,
+		leaderID: gen.NewUUID(),
+	}
+
+	if err := s.registerTasks(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SetNonceManager sets the nonce manager of the client, so that sweeps
+// hold the nonce lock of the account until the output is stored.
+func (s *Scheduler) SetNonceManager(nonces *eth.NonceManager) {
+	s.nonces = nonces
+}
+
+// millis converts a pause from configuration to a duration.
+func millis(ms uint64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// registerTasks registers the built-in tasks.
+func (s *Scheduler) registerTasks() error {
+	tasks := []Task{
+		NewTask(TaskUpdateLastBlock,
+			millis(s.cfg.Proc.UpdateLastBlockPause), s.updateLastBlock),
+		NewTask(TaskUpdateTransactions,
+			millis(s.cfg.Proc.UpdateTransactionsPause),
+			s.updateTransactions),
+		NewTask(TaskCollect, millis(s.cfg.Proc.CollectPause), s.collect),
+		NewTask(TaskUpdateAccounts, millis(s.cfg.Proc.CollectPause),
+			s.updateAccounts),
+		NewTask(TaskUpdateOutputs, millis(s.cfg.Proc.UpdateOutputsPause),
+			s.checkOutputs),
+	}
+
+	if len(s.cfg.Webhook.URLs) != 0 {
+		tasks = append(tasks, NewTask(TaskWebhooks,
+			millis(s.cfg.Webhook.DeliverPause), s.deliverEvents))
+	}
+
+	if s.cfg.Sweep.ColdAddress != "" {
+		tasks = append(tasks, NewTask(TaskSweep,
+			millis(s.cfg.Sweep.Pause), s.sweepAccounts))
+	}
+
+	for _, task := range tasks {
+		if err := s.Register(task); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Start starts a task scheduler.
@@ -65,14 +171,25 @@ func (s *Scheduler) Start() error {
 		return err
 	}
 
+	s.mtx.Lock()
 	s.lastBlockNum = last.Number()
-	s.wg.Add(4)
+	s.mtx.Unlock()
+
+	s.tasksMtx.Lock()
+	defer s.tasksMtx.Unlock()
+
+	if s.started {
+		return nil
+	}
+	s.started = true
 
-	go s.updateLastBlock()
-	go s.updateTransactions()
-	go s.collect()
-	go s.updateAccounts()
+	if s.cfg.Proc.LeaderElection {
+		s.wg.Add(1)
+		go s.elect()
+		return nil
+	}
 
+	s.startTasks()
 	return nil
 }
 
@@ -83,26 +200,64 @@ func (s *Scheduler) Close() {
 	s.wg.Wait()
 }
 
-func (s *Scheduler) updateLastBlock() {
-	defer s.wg.Done()
+// setLastBlock updates the last block number
+// and wakes up the collector.
+func (s *Scheduler) setLastBlock(number *big.Int) {
+	s.mtx.Lock()
+	s.lastBlockNum = number
+	s.mtx.Unlock()
+
+	select {
+	case s.newHeadCh <- struct{}{}:
+	default:
+	}
+}
+
+// updateLastBlock follows new blocks through newHeads subscription.
+// If the node does not support subscriptions (HTTP endpoints) or the
+// subscription fails, the last block is polled until the next attempt
+// to subscribe.
+func (s *Scheduler) updateLastBlock(ctx context.Context) error {
+	err := s.followHeads(ctx)
+	if err != nil && err != rpc.ErrNotificationsUnsupported {
+		log.Printf("new heads subscription failed: %s", err)
+		s.taskFailed(TaskUpdateLastBlock, err)
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	block, err := s.eth.BlockByNumber(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to get last block")
+	}
+
+	s.setLastBlock(block.Number())
+	return nil
+}
+
+// followHeads subscribes to new block headers and updates the last block
+// until the subscription fails or the context is done.
+func (s *Scheduler) followHeads(ctx context.Context) error {
+	heads := make(chan *types.Header)
+
+	sub, err := s.eth.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	log.Print("subscribed to new heads")
 
-	tic := time.NewTicker(time.Millisecond *
-		time.Duration(s.cfg.Proc.UpdateLastBlockPause))
 	for {
 		select {
-		case <-tic.C:
-			block, err := s.eth.BlockByNumber(s.ctx, nil)
-			if err != nil {
-				log.Printf("failed to get last block: %s", err)
-				continue
-			}
-
-			s.mtx.Lock()
-			s.lastBlockNum = block.Number()
-			s.mtx.Unlock()
-		case <-s.ctx.Done():
-			tic.Stop()
-			return
+		case head := <-heads:
+			s.setLastBlock(head.Number)
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
@@ -119,34 +274,27 @@ func (s *Scheduler) lastBlockFromDB() (uint64, error) {
 	return strconv.ParseUint(lastBlockSetting.Value, 10, 64)
 }
 
-func (s *Scheduler) updateLastBlockSetting(block *big.Int) error {
+func updateLastBlockSetting(q *reform.Querier, block *big.Int) error {
 	setting := &data.Setting{
 		Key:   "lastBlock",
 		Value: block.String(),
 	}
 
-	return s.db.Save(setting)
+	return q.Save(setting)
 }
 
-func (s *Scheduler) collect() {
-	defer s.wg.Done()
-
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		default:
-		}
-
-		if err := s.collectTxs(); err != nil {
-			log.Printf("failed to collect data: %s", err)
-		}
-		time.Sleep(time.Millisecond * time.Duration(s.cfg.Proc.CollectPause))
-	}
+// collect collects transactions of new blocks until an error occurs
+// or the context is done.
+func (s *Scheduler) collect(ctx context.Context) error {
+	return errors.Wrap(s.collectTxs(ctx), "failed to collect data")
 }
 
-func (s *Scheduler) getAccounts() (map[common.Address]struct{}, error) {
-	acs, err := s.eth.Accounts(s.ctx)
+// getAccounts returns managed accounts: accounts of the node,
+// deposit addresses derived from the extended public key
+// and watch-only addresses.
+func (s *Scheduler) getAccounts(ctx context.Context) (
+	map[common.Address]struct{}, error) {
+	acs, err := s.eth.Accounts(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -154,6 +302,16 @@ func (s *Scheduler) getAccounts() (map[common.Address]struct{}, error) {
 	for k := range acs {
 		m[common.HexToAddress(acs[k])] = struct{}{}
 	}
+
+	stored, err := s.db.SelectAllFrom(data.AccountTable,
+		"WHERE kind IN ($1, $2)", data.AccountDerived, data.AccountWatch)
+	if err != nil {
+		return nil, err
+	}
+	for k := range stored {
+		account := stored[k].(*data.Account)
+		m[common.HexToAddress(account.PublicKey)] = struct{}{}
+	}
 	return m, nil
 }
 
@@ -183,30 +341,39 @@ func (s *Scheduler) startBlock() (*big.Int, error) {
 	return new(big.Int).SetUint64(startBlock), nil
 }
 
-func (s *Scheduler) collectTxs() error {
+func (s *Scheduler) collectTxs(ctx context.Context) error {
 	currentBlock, err := s.startBlock()
 	if err != nil {
 		return err
 	}
 
-	// TODO need to handle the error
-	increaseBlockNum := func() error {
-		currentBlock = new(big.Int).Add(currentBlock, big.NewInt(1))
-		return s.updateLastBlockSetting(currentBlock)
+	// advance stores the processed block and moves the collector to the
+	// next block, it must be called in the transaction with the data
+	// of the block.
+	advance := func(q *reform.Querier, block *types.Block) error {
+		if err := s.saveBlock(q, block); err != nil {
+			return err
+		}
+		return updateLastBlockSetting(q, new(big.Int).Add(block.Number(),
+			big.NewInt(1)))
 	}
 
 	delay := func(current, lastProcessed *big.Int) (execute bool) {
 		if current.Cmp(lastProcessed) > 0 {
-			time.Sleep(time.Millisecond *
-				time.Duration(s.cfg.Proc.CollectPause))
+			select {
+			case <-s.newHeadCh:
+			case <-time.After(time.Millisecond *
+				time.Duration(s.cfg.Proc.CollectPause)):
+			case <-ctx.Done():
+			}
 			return true
 		}
 		return false
 	}
 
-	signer := types.NewEIP155Signer(s.netID)
+	signer := types.LatestSignerForChainID(s.netID)
 
-	for {
+	for ctx.Err() == nil {
 		s.mtx.RLock()
 		lastProcessedBlock := s.lastBlockNum
 		s.mtx.RUnlock()
@@ -215,23 +382,41 @@ func (s *Scheduler) collectTxs() error {
 			continue
 		}
 
-		accounts, err := s.getAccounts()
+		accounts, err := s.getAccounts(ctx)
+		if err != nil {
+			return err
+		}
+
+		block, err := s.eth.BlockByNumber(ctx, currentBlock)
 		if err != nil {
 			return err
 		}
 
-		block, err := s.eth.BlockByNumber(s.ctx, currentBlock)
+		ancestor, err := s.checkReorg(ctx, block, accounts)
 		if err != nil {
 			return err
 		}
 
+		if ancestor != nil {
+			currentBlock = new(big.Int).Add(ancestor, big.NewInt(1))
+			continue
+		}
+
 		txs := block.Transactions()
 
 		log.Printf("block: %d, transactions: %d",
 			block.Number().Uint64(), len(txs))
 
 		if len(txs) == 0 {
-			_ = increaseBlockNum()
+			if err := s.db.InTransaction(func(t *reform.TX) error {
+				if err := s.checkLease(t.Querier); err != nil {
+					return err
+				}
+				return advance(t.Querier, block)
+			}); err != nil {
+				return err
+			}
+			currentBlock = new(big.Int).Add(currentBlock, big.NewInt(1))
 			continue
 		}
 
@@ -244,78 +429,338 @@ func (s *Scheduler) collectTxs() error {
 		var accountsToUpdate []string
 		var transactions []*data.Transaction
 
-		results := make(chan *result)
-		complete := make(chan struct{})
+		candidates := getCandidates(txs, signer, accounts)
 
-		go func() {
-			defer close(complete)
-			for res := range results {
-				accountsToUpdate = append(accountsToUpdate, res.acc...)
-				transactions = append(transactions, res.tx)
-			}
-		}()
+		receipts, logs, err := s.blockReceipts(ctx, block, candidates,
+			accounts)
+		if err != nil {
+			return err
+		}
 
-		sem := make(chan struct{}, runtime.NumCPU())
+		transfers := getTokenTransfers(logs, accounts, block.Number(),
+			block.Time(), confirm)
 
-		for _, tx := range txs {
-			sem <- struct{}{}
+		for _, c := range candidates {
+			receipt, ok := receipts[c.tx.Hash()]
+			if !ok {
+				return errors.Errorf("receipt of transaction %s not found",
+					c.tx.Hash().String())
+			}
 
-			go func(tx *types.Transaction) {
-				defer func() { <-sem }()
-				s.checkTransaction(tx, signer, accounts, confirm,
-					block.Number(), block.Time(), results)
-			}(tx)
-		}
+			tx, acc := checkTransaction(c.tx, c.from, receipt, accounts,
+				confirm, block)
+			if tx == nil {
+				continue
+			}
 
-		for i := 0; i < cap(sem); i++ {
-			sem <- struct{}{}
+			transactions = append(transactions, tx)
+			accountsToUpdate = append(accountsToUpdate, acc...)
 		}
 
-		close(results)
-		<-complete
+		if s.cfg.Proc.TraceInternalTxs {
+			internal, acc, err := s.internalTransactions(ctx, block,
+				accounts, confirm)
+			if err != nil {
+				return err
+			}
+
+			transactions = append(transactions, internal...)
+			accountsToUpdate = append(accountsToUpdate, acc...)
+		}
 
 		select {
-		case s.updBalCh <- accountsToUpdate:
+		case s.updBalCh <- balanceUpdate{accountsToUpdate, block.Number()}:
 		// TODO: hardcoded timeout
 		case <-time.After(time.Second * 5):
 		}
 
 		err = s.db.InTransaction(func(t *reform.TX) error {
+			if err := s.checkLease(t.Querier); err != nil {
+				return err
+			}
 			for k := range transactions {
 				err := t.Insert(transactions[k])
 				if err != nil {
 					return err
 				}
 			}
-			return nil
+			for k := range transfers {
+				err := t.Insert(transfers[k])
+				if err != nil {
+					return err
+				}
+			}
+			for k := range transactions {
+				if !isDeposit(transactions[k], accounts) {
+					continue
+				}
+				err := s.depositEvents(t.Querier, transactions[k],
+					true, 0)
+				if err != nil {
+					return err
+				}
+			}
+			return advance(t.Querier, block)
 		})
 
+		if err == ErrNotLeader {
+			return err
+		}
+
 		if err != nil {
 			log.Printf("failed to processed block %s, error: %v",
 				block.Number(), err)
+			s.taskFailed(TaskCollect, err)
 			continue
 		}
 
-		_ = increaseBlockNum()
+		currentBlock = new(big.Int).Add(currentBlock, big.NewInt(1))
+	}
+
+	return nil
+}
+
+// saveBlock stores the hash of a processed block, so that the next block
+// can be checked against it, and prunes blocks which are too deep to be
+// affected by a reorganization.
+func (s *Scheduler) saveBlock(q *reform.Querier, block *types.Block) error {
+	record := &data.Block{
+		Number:     block.NumberU64(),
+		Hash:       block.Hash().String(),
+		ParentHash: block.ParentHash().String(),
+	}
+
+	if err := q.Save(record); err != nil {
+		return err
+	}
+
+	if record.Number <= s.cfg.Proc.MaxReorgDepth {
+		return nil
+	}
+
+	_, err := q.DeleteFrom(data.BlockTable, "WHERE number < $1",
+		record.Number-s.cfg.Proc.MaxReorgDepth)
+	return err
+}
+
+func (s *Scheduler) storedBlock(number uint64) (*data.Block, error) {
+	block := &data.Block{}
+	if err := s.db.FindByPrimaryKeyTo(block, number); err != nil {
+		if err != reform.ErrNoRows {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return block, nil
+}
+
+// checkReorg compares the parent hash of a new block with the hash of
+// the previously processed block. If they differ, it walks back to the
+// common ancestor, rolls back the data derived from orphaned blocks and
+// returns the number of the common ancestor.
+func (s *Scheduler) checkReorg(ctx context.Context, block *types.Block,
+	accounts map[common.Address]struct{}) (*big.Int, error) {
+	if block.NumberU64() == 0 {
+		return nil, nil
 	}
+
+	parent, err := s.storedBlock(block.NumberU64() - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if parent == nil || parent.Hash == block.ParentHash().String() {
+		return nil, nil
+	}
+
+	ancestor, err := s.findCommonAncestor(ctx, parent.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.rollback(ancestor, accounts); err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetUint64(ancestor), nil
 }
 
-func (s *Scheduler) updateTransactions() {
-	defer s.wg.Done()
+// findCommonAncestor walks back from the given block number until the
+// stored block hash matches the canonical chain.
+func (s *Scheduler) findCommonAncestor(ctx context.Context,
+	number uint64) (uint64, error) {
+	for depth := uint64(0); depth < s.cfg.Proc.MaxReorgDepth; depth++ {
+		stored, err := s.storedBlock(number)
+		if err != nil {
+			return 0, err
+		}
+
+		// There is no information about earlier blocks,
+		// so consider it as the common ancestor.
+		if stored == nil {
+			return number, nil
+		}
 
-	update := func() error {
-		items, err := s.db.SelectAllFrom(data.TransactionTable,
-			"WHERE confirmations <= $1", 6)
+		block, err := s.eth.BlockByNumber(ctx,
+			new(big.Int).SetUint64(number))
 		if err != nil {
+			return 0, err
+		}
+
+		if block.Hash().String() == stored.Hash || number == 0 {
+			return number, nil
+		}
+
+		number--
+	}
+
+	return 0, errors.Errorf("common ancestor not found within %d blocks",
+		s.cfg.Proc.MaxReorgDepth)
+}
+
+// rollback removes transactions, balance history and blocks above
+// the common ancestor, returns outputs mined above it to pending, moves
+// the collector back and refreshes balances of touched accounts.
+func (s *Scheduler) rollback(ancestor uint64,
+	accounts map[common.Address]struct{}) error {
+	var touched []string
+	var removed []string
+	var removedTransfers uint
+	var removedOutputs int
+
+	err := s.db.InTransaction(func(t *reform.TX) error {
+		if err := s.checkLease(t.Querier); err != nil {
 			return err
 		}
 
-		s.mtx.RLock()
-		lastBlock := s.lastBlockNum.Uint64()
-		s.mtx.RUnlock()
+		items, err := t.SelectAllFrom(data.TransactionTable,
+			"WHERE block > $1", ancestor)
+		if err != nil {
+			return err
+		}
+
+		for k := range items {
+			tx := items[k].(*data.Transaction)
+			removed = append(removed, tx.Hash)
+			touched = append(touched, getTargetAccounts(accounts,
+				common.HexToAddress(tx.From),
+				common.HexToAddress(tx.To))...)
+
+			if isDeposit(tx, accounts) {
+				if err := s.enqueueEvent(t.Querier,
+					EventDepositRemoved, EventDepositRemoved+":"+tx.ID,
+					s.newDepositData(tx, 0)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := t.DeleteFrom(data.TransactionTable,
+			"WHERE block > $1", ancestor); err != nil {
+			return err
+		}
+
+		transfers, err := t.SelectAllFrom(data.TokenTransferTable,
+			"WHERE block > $1", ancestor)
+		if err != nil {
+			return err
+		}
+
+		for k := range transfers {
+			transfer := transfers[k].(*data.TokenTransfer)
+			touched = append(touched, getTargetAccounts(accounts,
+				common.HexToAddress(transfer.From),
+				common.HexToAddress(transfer.To))...)
+		}
+
+		removedTransfers, err = t.DeleteFrom(data.TokenTransferTable,
+			"WHERE block > $1", ancestor)
+		if err != nil {
+			return err
+		}
+
+		removedOutputs, err = s.rollbackOutputs(t.Querier, ancestor)
+		if err != nil {
+			return err
+		}
+
+		balances, err := t.SelectAllFrom(data.BalanceTable,
+			"WHERE block > $1", ancestor)
+		if err != nil {
+			return err
+		}
+
+		for k := range balances {
+			touched = append(touched, balances[k].(*data.Balance).Account)
+		}
+
+		if _, err := t.DeleteFrom(data.BalanceTable,
+			"WHERE block > $1", ancestor); err != nil {
+			return err
+		}
+
+		if _, err := t.DeleteFrom(data.BlockTable,
+			"WHERE number > $1", ancestor); err != nil {
+			return err
+		}
+
+		return updateLastBlockSetting(t.Querier,
+			new(big.Int).SetUint64(ancestor+1))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("chain reorganization: rolled back to block %d,"+
+		" removed transactions: %d %v, removed token transfers: %d,"+
+		" outputs returned to pending: %d, accounts to update: %v",
+		ancestor, len(removed), removed, removedTransfers, removedOutputs,
+		touched)
+
+	if len(touched) != 0 {
+		select {
+		case s.updBalCh <- balanceUpdate{touched,
+			new(big.Int).SetUint64(ancestor)}:
+		// TODO: hardcoded timeout
+		case <-time.After(time.Second * 5):
+		}
+	}
+
+	return nil
+}
+
+// updateTransactions updates confirmations of transactions
+// and token transfers.
+func (s *Scheduler) updateTransactions(ctx context.Context) error {
+	items, err := s.db.SelectAllFrom(data.TransactionTable,
+		"WHERE confirmations <= $1", s.trackedConfirmations())
+	if err != nil {
+		return err
+	}
+
+	var accounts map[common.Address]struct{}
+	if len(s.cfg.Webhook.URLs) != 0 {
+		if accounts, err = s.getAccounts(ctx); err != nil {
+			return err
+		}
+	}
+
+	transfers, err := s.db.SelectAllFrom(data.TokenTransferTable,
+		"WHERE confirmations <= $1", s.trackedConfirmations())
+	if err != nil {
+		return err
+	}
+
+	s.mtx.RLock()
+	lastBlock := s.lastBlockNum.Uint64()
+	s.mtx.RUnlock()
 
+	// The events are queued in the same transaction as the
+	// confirmations. Repeated events are ignored.
+	return s.inLease(func(q *reform.Querier) error {
 		for k := range items {
 			tx := *items[k].(*data.Transaction)
+			prev := tx.Confirmations
 
 			if tx.Block != nil && lastBlock > *tx.Block {
 				confirm := lastBlock - *tx.Block
@@ -325,55 +770,193 @@ func (s *Scheduler) updateTransactions() {
 				}
 			}
 
-			if err := s.db.Save(&tx); err != nil {
+			if tx.Confirmations > prev && isDeposit(&tx, accounts) {
+				if err := s.depositEvents(q, &tx,
+					false, prev); err != nil {
+					return err
+				}
+			}
+
+			if err := q.Save(&tx); err != nil {
+				return err
+			}
+		}
+
+		for k := range transfers {
+			transfer := *transfers[k].(*data.TokenTransfer)
+
+			if transfer.Block != nil && lastBlock > *transfer.Block {
+				confirm := lastBlock - *transfer.Block
+
+				if confirm > transfer.Confirmations {
+					transfer.Confirmations = confirm
+				}
+			}
+
+			if err := q.Save(&transfer); err != nil {
 				return err
 			}
 		}
 
 		return nil
+	})
+}
+
+// getCandidates returns transactions which can touch the accounts:
+// transactions from or to the accounts and contract creations.
+func getCandidates(txs []*types.Transaction, signer types.Signer,
+	accounts map[common.Address]struct{}) (result []candidate) {
+	for _, tx := range txs {
+		from, err := signer.Sender(tx)
+		if err != nil {
+			log.Printf("invalid transaction %s: %s",
+				tx.Hash().String(), err)
+			continue
+		}
+
+		_, fromOk := accounts[from]
+
+		toOk := tx.To() == nil
+		if !toOk {
+			_, toOk = accounts[*tx.To()]
+		}
+
+		if fromOk || toOk {
+			result = append(result, candidate{tx: tx, from: from})
+		}
 	}
 
-	tic := time.NewTicker(time.Millisecond *
-		time.Duration(s.cfg.Proc.UpdateTransactionsPause))
-	for {
-		select {
-		case <-tic.C:
-			if err := update(); err != nil {
-				log.Printf("failed to update transactions: %s", err)
+	return result
+}
+
+// blockReceipts returns receipts of the candidates and ERC-20 Transfer
+// logs of the block related to the accounts. If the node supports
+// eth_getBlockReceipts, all receipts of the block are fetched with one
+// call, otherwise the receipts of the candidates are fetched in batches
+// and the logs are filtered by the node. Receipts of another block, which
+// has replaced the block after a chain reorganization, are rejected.
+func (s *Scheduler) blockReceipts(ctx context.Context, block *types.Block,
+	candidates []candidate, accounts map[common.Address]struct{}) (
+	map[common.Hash]*types.Receipt, []*types.Log, error) {
+	receipts := make(map[common.Hash]*types.Receipt)
+
+	all, err := s.eth.BlockReceipts(ctx, block.Hash())
+	if err == nil {
+		var logs []*types.Log
+		for _, receipt := range all {
+			if err := checkReceiptBlock(receipt, block); err != nil {
+				return nil, nil, err
 			}
-		case <-s.ctx.Done():
-			tic.Stop()
-			return
+			receipts[receipt.TxHash] = receipt
+			logs = append(logs, receipt.Logs...)
 		}
+		return receipts, logs, nil
+	}
+
+	if err != eth.ErrBlockReceiptsUnsupported {
+		return nil, nil, err
 	}
-}
 
-func (s *Scheduler) checkTransaction(
-	transaction *types.Transaction, signer types.Signer,
-	accounts map[common.Address]struct{}, confirmations uint64, block *big.Int,
-	timestamp uint64, results chan *result) {
+	hashes := make([]common.Hash, len(candidates))
+	for k := range candidates {
+		hashes[k] = candidates[k].tx.Hash()
+	}
 
-	from, err := signer.Sender(transaction)
+	list, err := s.eth.TransactionReceipts(ctx, hashes)
 	if err != nil {
-		log.Printf("invalid transaction %s: %s",
-			transaction.Hash().String(), err)
-		return
+		return nil, nil, err
+	}
+
+	for k := range list {
+		if err := checkReceiptBlock(list[k], block); err != nil {
+			return nil, nil, err
+		}
+		receipts[hashes[k]] = list[k]
 	}
 
-	receipt, err := s.eth.TransactionReceipt(s.ctx, transaction.Hash())
+	logs, err := s.transferLogs(ctx, block, accounts)
 	if err != nil {
-		log.Printf("failed to get transaction receipt: %s", err)
-		return
+		return nil, nil, err
 	}
 
+	return receipts, logs, nil
+}
+
+// checkReceiptBlock returns an error if the receipt is not of the block.
+func checkReceiptBlock(receipt *types.Receipt, block *types.Block) error {
+	if receipt.BlockHash != block.Hash() {
+		return errors.Errorf("receipt of transaction %s is of block %s,"+
+			" expected block %s", receipt.TxHash.String(),
+			receipt.BlockHash.String(), block.Hash().String())
+	}
+	return nil
+}
+
+// transferLogs returns ERC-20 Transfer logs of the block,
+// where the sender or the recipient is one of the accounts.
+func (s *Scheduler) transferLogs(ctx context.Context, block *types.Block,
+	accounts map[common.Address]struct{}) ([]*types.Log, error) {
+	if len(accounts) == 0 {
+		return nil, nil
+	}
+
+	var topics []common.Hash
+	for acc := range accounts {
+		topics = append(topics, common.BytesToHash(acc.Bytes()))
+	}
+
+	hash := block.Hash()
+	queries := []ethereum.FilterQuery{
+		{
+			BlockHash: &hash,
+			Topics:    [][]common.Hash{{transferEventID}, topics},
+		},
+		{
+			BlockHash: &hash,
+			Topics:    [][]common.Hash{{transferEventID}, nil, topics},
+		},
+	}
+
+	// A transfer between two accounts matches both queries.
+	found := make(map[uint]*types.Log)
+	for _, query := range queries {
+		logs, err := s.eth.FilterLogs(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for k := range logs {
+			found[logs[k].Index] = &logs[k]
+		}
+	}
+
+	result := make([]*types.Log, 0, len(found))
+	for _, l := range found {
+		result = append(result, l)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Index < result[j].Index
+	})
+
+	return result, nil
+}
+
+// checkTransaction converts a transaction related to the accounts
+// to a local transaction and returns the accounts it touches.
+func checkTransaction(transaction *types.Transaction, from common.Address,
+	receipt *types.Receipt, accounts map[common.Address]struct{},
+	confirmations uint64, block *types.Block) (*data.Transaction,
+	[]string) {
 	to := getToAccount(transaction, receipt)
 	targetAccounts := getTargetAccounts(accounts, from, to)
 	if len(targetAccounts) == 0 {
-		return
+		return nil, nil
 	}
 
 	localTransaction := fillTransaction(transaction.Hash(), from, to,
-		transaction.Value(), block, timestamp, confirmations)
+		transaction.Value(), block.Number(), block.Time(), confirmations)
+	setFees(localTransaction, transaction, receipt, block.BaseFee())
 
 	switch receipt.Status {
 	case types.ReceiptStatusFailed:
@@ -383,65 +966,70 @@ func (s *Scheduler) checkTransaction(
 	default:
 		log.Printf("unknown status transaction status: %s",
 			transaction.Hash().String())
-		return
+		return nil, nil
 	}
 
-	results <- &result{tx: localTransaction, acc: targetAccounts}
+	return localTransaction, targetAccounts
 }
 
-func (s *Scheduler) updateAccounts() {
-	defer s.wg.Done()
-
-	update := func(accounts []string) {
-		for k := range accounts {
-			balance, err := s.eth.BalanceAt(
-				s.ctx, common.HexToAddress(accounts[k]), nil)
-			if err != nil {
-				log.Printf("failed to get account balance: %s", err)
-				return
-			}
-
-			account := &data.Account{}
-			if err := s.db.FindOneTo(account,
-				"public_key", accounts[k]); err != nil {
-				if err != reform.ErrNoRows {
-					log.Printf("failed to find account: %s", err)
-					return
-				}
-
-				account.ID = gen.NewUUID()
-				account.PublicKey = accounts[k]
-			}
-
-			account.Balance = balance.String()
-
-			if err := s.db.Save(account); err != nil {
-				log.Printf("failed to save account: %s", err)
-				return
-			}
+// updateAccounts updates balances of all node accounts at the last block
+// and then of the accounts touched by collected transactions at their
+// blocks until the context is done.
+func (s *Scheduler) updateAccounts(ctx context.Context) error {
+	update := func(accounts []string, number *big.Int) {
+		if err := s.updateBalances(ctx, accounts, number); err != nil {
+			log.Printf("failed to update balances: %s", err)
+			s.taskFailed(TaskUpdateAccounts, err)
 		}
 	}
 
-	accounts, err := s.eth.Accounts(s.ctx)
+	accounts, err := s.eth.Accounts(ctx)
 	if err != nil {
-		log.Printf("failed to get accounts: %s", err)
-		return
+		return errors.Wrap(err, "failed to get accounts")
 	}
-	update(accounts)
+	s.mtx.RLock()
+	number := new(big.Int).Set(s.lastBlockNum)
+	s.mtx.RUnlock()
+
+	update(accounts, number)
 
 	for {
 		select {
-		case accounts, ok := <-s.updBalCh:
+		case upd, ok := <-s.updBalCh:
 			if !ok {
-				return
+				return nil
 			}
-			update(accounts)
-		case <-s.ctx.Done():
-			return
+			update(upd.accounts, upd.block)
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
 
+// setFees stores the gas and the fee paid by a transaction. For blocks
+// with a base fee, the fee is split into the burned base fee
+// and the tip paid to the miner.
+func setFees(tx *data.Transaction, transaction *types.Transaction,
+	receipt *types.Receipt, baseFee *big.Int) {
+	price := effectiveGasPrice(transaction, baseFee)
+	gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
+
+	tx.GasLimit = pointer.ToUint64(transaction.Gas())
+	tx.GasUsed = pointer.ToUint64(receipt.GasUsed)
+	tx.EffectiveGasPrice = pointer.ToString(price.String())
+	tx.Fee = pointer.ToString(new(big.Int).Mul(price, gasUsed).String())
+	tx.TxType = pointer.ToUint64(uint64(transaction.Type()))
+
+	if baseFee == nil {
+		return
+	}
+
+	tip := new(big.Int).Sub(price, baseFee)
+	tx.BurnedFee = pointer.ToString(
+		new(big.Int).Mul(baseFee, gasUsed).String())
+	tx.TipFee = pointer.ToString(new(big.Int).Mul(tip, gasUsed).String())
+}
+
 func fillTransaction(hash common.Hash, from, to common.Address,
 	amount, blockNumber *big.Int, blockTimestamp uint64,
 	confirmations uint64) *data.Transaction {
@@ -457,6 +1045,46 @@ func fillTransaction(hash common.Hash, from, to common.Address,
 	}
 }
 
+// getTokenTransfers decodes ERC-20 Transfer events from logs
+// and returns the transfers related to the accounts. Logs removed
+// by a chain reorganization are skipped.
+func getTokenTransfers(logs []*types.Log,
+	accounts map[common.Address]struct{}, blockNumber *big.Int,
+	blockTimestamp uint64,
+	confirmations uint64) (result []*data.TokenTransfer) {
+	for _, l := range logs {
+		// ERC-721 Transfer event has the same signature,
+		// but the token id is indexed, so there are four topics.
+		if l.Removed || len(l.Topics) != 3 ||
+			l.Topics[0] != transferEventID ||
+			len(l.Data) != common.HashLength {
+			continue
+		}
+
+		from := common.BytesToAddress(l.Topics[1].Bytes())
+		to := common.BytesToAddress(l.Topics[2].Bytes())
+
+		if len(getTargetAccounts(accounts, from, to)) == 0 {
+			continue
+		}
+
+		result = append(result, &data.TokenTransfer{
+			ID:            gen.NewUUID(),
+			Hash:          l.TxHash.String(),
+			Token:         strings.ToLower(l.Address.String()),
+			LogIndex:      uint64(l.Index),
+			From:          strings.ToLower(from.String()),
+			To:            strings.ToLower(to.String()),
+			Amount:        new(big.Int).SetBytes(l.Data).String(),
+			Block:         pointer.ToUint64(blockNumber.Uint64()),
+			Timestamp:     pointer.ToUint64(blockTimestamp),
+			Confirmations: confirmations,
+		})
+	}
+
+	return result
+}
+
 func getToAccount(tx *types.Transaction, tr *types.Receipt) common.Address {
 	if tx.To() != nil {
 		return *tx.To()
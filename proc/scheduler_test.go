@@ -1,7 +1,1284 @@
 package proc_test
 
-import "testing"
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/db"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/gen"
+	"github.com/dzeckelev/geth-wrapper/proc"
+)
+
+var blockColumns = []string{"number", "hash", "parent_hash"}
+
+func newDB(t *testing.T) (*reform.DB, sqlmock.Sqlmock) {
+	sqlDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataBase, err := db.NewDB(sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataBase, sqlMock
+}
+
+func newEthClient(t *testing.T) (*eth.MockClient, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, eth.MockChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return eth.NewMockClient(big.NewInt(1e18), opts), opts.From
+}
+
+func blockRow(block *types.Block) []driver.Value {
+	return []driver.Value{block.NumberU64(), block.Hash().String(),
+		block.ParentHash().String()}
+}
 
 func TestScheduler_Start(t *testing.T) {
+	dataBase, _ := newDB(t)
+	client, _ := newEthClient(t)
+
+	scheduler, err := proc.NewScheduler(context.Background(),
+		eth.MockChainID, config.NewConfig(), dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errNode := errors.New("node is down")
+	client.FailNext("BlockByNumber", errNode)
+
+	if err := scheduler.Start(); err != errNode {
+		t.Fatalf("expected %v, got %v", errNode, err)
+	}
+
+	if err := scheduler.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler.Close()
+}
+
+func TestScheduler_NewHeadWakesCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataBase, sqlMock := newDB(t)
+	client, _ := newEthClient(t)
+
+	cfg := config.NewConfig()
+	// Without a new head the collector sleeps for the whole pause.
+	cfg.Proc.CollectPause = uint64(time.Hour / time.Millisecond)
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proc.SetLastBlock(scheduler, big.NewInt(0))
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "settings"`).WillReturnRows(
+		sqlmock.NewRows([]string{"key", "value"}).AddRow("lastBlock", "1"))
+
+	// The collector stops on the first call after the wakeup.
+	errStop := errors.New("stop")
+	client.FailNext("Accounts", errStop)
+
+	go proc.FollowHeads(scheduler, ctx)
+
+	collected := make(chan error, 1)
+	go func() {
+		collected <- proc.Collect(scheduler, ctx)
+	}()
+
+	// Blocks are mined until the subscription delivers a head.
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-collected:
+			if !errors.Is(err, errStop) {
+				t.Fatalf("expected %v, got %v", errStop, err)
+			}
+			if err := sqlMock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("collector was not woken up by a new head")
+		case <-time.After(50 * time.Millisecond):
+			client.Mine()
+		}
+	}
+}
+
+// tokenCode is a creation code of a fake token, which emits ERC-20
+// Transfer event from the caller to the address in the first word
+// of the call data with the amount in the second word.
+var tokenCode = common.FromHex("0x603180600b6000396000f3" +
+	"6020356000526000353" + "37f" +
+	"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" +
+	"60206000a300")
+
+func newKey(t *testing.T) *bind.TransactOpts {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, eth.MockChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// sendRawTx sends a transaction to the simulated blockchain
+// without mining it.
+func sendRawTx(t *testing.T, client *eth.MockClient,
+	opts *bind.TransactOpts, to *common.Address, value *big.Int,
+	input []byte) *types.Transaction {
+	ctx := context.Background()
+
+	nonce, err := client.Backend.PendingNonceAt(ctx, opts.From)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	price, err := client.Backend.SuggestGasPrice(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := opts.Signer(opts.From, types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Value:    value,
+		Gas:      100000,
+		GasPrice: price,
+		Data:     input,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Backend.SendTransaction(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func deployToken(t *testing.T, client *eth.MockClient,
+	opts *bind.TransactOpts) common.Address {
+	tx := sendRawTx(t, client, opts, nil, nil, tokenCode)
+	return crypto.CreateAddress(opts.From, tx.Nonce())
+}
+
+func sendToken(t *testing.T, client *eth.MockClient,
+	opts *bind.TransactOpts, token, to common.Address,
+	amount int64) *types.Transaction {
+	input := append(common.LeftPadBytes(to.Bytes(), common.HashLength),
+		common.LeftPadBytes(big.NewInt(amount).Bytes(),
+			common.HashLength)...)
+	return sendRawTx(t, client, opts, &token, nil, input)
+}
+
+func TestScheduler_BlockReceipts(t *testing.T) {
+	ctx := context.Background()
+	dataBase, _ := newDB(t)
+
+	managed := newKey(t)
+	outsider := newKey(t)
+	holder := common.HexToAddress("0x3e1f7a52f6b3b8a4e0e2f5cfa1d5b1c3e5b5d7a9")
+	other := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	client := eth.NewMockClient(big.NewInt(1e18), managed, outsider)
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID,
+		config.NewConfig(), dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := deployToken(t, client, outsider)
+	client.Mine()
+
+	payment := sendRawTx(t, client, managed, &other, big.NewInt(1), nil)
+	creation := sendRawTx(t, client, outsider, nil, nil, tokenCode)
+	withdrawal := sendToken(t, client, managed, token, other, 1)
+	sendRawTx(t, client, outsider, &other, big.NewInt(1), nil)
+	deposit := sendToken(t, client, outsider, token, holder, 2)
+	foreign := sendToken(t, client, outsider, token, other, 3)
+	internal := sendToken(t, client, managed, token, holder, 4)
+	block := client.Mine()
+
+	accounts := map[common.Address]struct{}{managed.From: {}, holder: {}}
+
+	candidates := proc.GetCandidates(block.Transactions(),
+		types.LatestSignerForChainID(eth.MockChainID), accounts)
+
+	hashes := func(txs ...*types.Transaction) (result []common.Hash) {
+		for _, tx := range txs {
+			result = append(result, tx.Hash())
+		}
+		return result
+	}
+
+	check := func(name string, expReceipts, expLogs []common.Hash) {
+		t.Helper()
+
+		receipts, logs, err := proc.BlockReceipts(scheduler, ctx, block,
+			candidates, accounts)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if len(receipts) != len(expReceipts) {
+			t.Fatalf("%s: expected %d receipts, got %d", name,
+				len(expReceipts), len(receipts))
+		}
+		for _, hash := range expReceipts {
+			if receipt := receipts[hash]; receipt == nil ||
+				receipt.TxHash != hash {
+				t.Fatalf("%s: no receipt of %s", name, hash.String())
+			}
+		}
+
+		var got []common.Hash
+		for _, l := range logs {
+			got = append(got, l.TxHash)
+		}
+		if !reflect.DeepEqual(expLogs, got) {
+			t.Fatalf("%s: expected logs of %v, got %v", name,
+				expLogs, got)
+		}
+	}
+
+	// Receipts are fetched only for the candidates, the node returns
+	// Transfer logs of the accounts, a transfer between two accounts
+	// is returned once.
+	check("fallback", hashes(payment, creation, withdrawal, internal),
+		hashes(withdrawal, deposit, internal))
+
+	// Receipts of the block, which has replaced the processed block
+	// at the same height, are rejected.
+	header := block.Header()
+	header.Extra = []byte("side chain")
+	replaced := types.NewBlockWithHeader(header).WithBody(
+		block.Transactions(), nil)
+	if _, _, err := proc.BlockReceipts(scheduler, ctx, replaced,
+		candidates, accounts); err == nil {
+		t.Fatal("expected error for receipts of another block")
+	}
+
+	// All receipts and logs of the block are fetched with one call.
+	client.BlockReceiptsSupported = true
+	check("block receipts", hashes(block.Transactions()...),
+		hashes(withdrawal, deposit, foreign, internal))
+
+	errNode := errors.New("node is down")
+	client.FailNext("BlockReceipts", errNode)
+	if _, _, err := proc.BlockReceipts(scheduler, ctx, block, candidates,
+		accounts); err != errNode {
+		t.Fatalf("expected %v, got %v", errNode, err)
+	}
+
+	// The node is called with the context of the task.
+	client.SetLatency(time.Hour)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := proc.BlockReceipts(scheduler, canceled, block,
+		candidates, accounts); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestScheduler_CheckReorg(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	client, from := newEthClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	cfg := config.NewConfig()
+	cfg.Webhook.URLs = []string{"http://localhost/webhook"}
+	cfg.Webhook.Secret = "secret"
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Mine()
+
+	genesis, err := client.BlockByNumber(ctx, big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The side chain replaces blocks 1 and 2.
+	if err := client.Fork(0); err != nil {
+		t.Fatal(err)
+	}
+	client.Mine()
+	client.Mine()
+	client.Mine()
+
+	block, err := client.BlockByNumber(ctx, big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &data.Transaction{
+		ID:     gen.NewUUID(),
+		Hash:   *hash,
+		From:   strings.ToLower(from.String()),
+		To:     strings.ToLower(to.String()),
+		Amount: "1000",
+		Status: pointer.ToString(data.TxSuccessful),
+		Block:  pointer.ToUint64(1),
+	}
+
+	var txRow []driver.Value
+	for _, v := range tx.Values() {
+		txRow = append(txRow, v)
+	}
+
+	holder := common.HexToAddress("0x3e1f7a52f6b3b8a4e0e2f5cfa1d5b1c3e5b5d7a9")
+
+	transfer := &data.TokenTransfer{
+		ID:     gen.NewUUID(),
+		Hash:   *hash,
+		Token:  "0xdac17f958d2ee523a2206206994597c13d831ec7",
+		From:   strings.ToLower(to.String()),
+		To:     strings.ToLower(holder.String()),
+		Amount: "1000",
+		Block:  pointer.ToUint64(2),
+	}
+
+	var transferRow []driver.Value
+	for _, v := range transfer.Values() {
+		transferRow = append(transferRow, v)
+	}
+
+	// The output mined in the orphaned block and the output replaced
+	// by it are pending again.
+	mined := &data.Output{
+		ID:                gen.NewUUID(),
+		Hash:              *hash,
+		Account:           strings.ToLower(from.String()),
+		MinedHash:         hash,
+		Status:            data.OutputSuccessful,
+		Block:             pointer.ToUint64(1),
+		BlockHash:         pointer.ToString(orphaned.Hash().String()),
+		GasUsed:           pointer.ToUint64(21000),
+		EffectiveGasPrice: pointer.ToString("1"),
+		Confirmations:     1,
+		MinedAt:           pointer.ToUint64(orphaned.Time()),
+		Kind:              data.OutputWithdrawal,
+	}
+
+	replaced := &data.Output{
+		ID: gen.NewUUID(),
+		Hash: "0x64e604787cbf194841e7b68d7cd28786" +
+			"f6c9a0a3ab9f8b0a0e87cb4387ab0107",
+		Account:    mined.Account,
+		ReplacedBy: pointer.ToString(mined.ID),
+		MinedHash:  hash,
+		Status:     data.OutputReplaced,
+		Kind:       data.OutputWithdrawal,
+	}
+
+	// The balance history of an account changed by an internal
+	// transaction of the orphaned block.
+	internal := common.HexToAddress(
+		"0x5aeda56215b167893e80b4fe645ba6d5bab767de")
+
+	balance := &data.Balance{
+		ID:        gen.NewUUID(),
+		Account:   strings.ToLower(internal.String()),
+		Block:     2,
+		Timestamp: block.Time(),
+		Balance:   "1000",
+	}
+
+	var balanceRow []driver.Value
+	for _, v := range balance.Values() {
+		balanceRow = append(balanceRow, v)
+	}
+
+	expSelectBlockSQL := `SELECT (.+) FROM "blocks"`
+
+	sqlMock.ExpectQuery(expSelectBlockSQL).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(blockColumns).AddRow(blockRow(orphaned)...))
+	sqlMock.ExpectQuery(expSelectBlockSQL).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(blockColumns).AddRow(blockRow(orphaned)...))
+	sqlMock.ExpectQuery(expSelectBlockSQL).WithArgs(0).WillReturnRows(
+		sqlmock.NewRows(blockColumns).AddRow(blockRow(genesis)...))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "transactions"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(tx.Table().Columns()).
+			AddRow(txRow...))
+	sqlMock.ExpectExec(`DELETE FROM "transactions"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "token_transfers"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(transfer.Table().Columns()).
+			AddRow(transferRow...))
+	sqlMock.ExpectExec(`DELETE FROM "token_transfers"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE block > \$1`).
+		WithArgs(0).WillReturnRows(sqlmock.NewRows(mined.Table().Columns()).
+		AddRow(outputRow(mined)...))
+	sqlMock.ExpectExec(`INSERT INTO webhook_events`).WithArgs(
+		sqlmock.AnyArg(), proc.EventWithdrawalRemoved,
+		proc.EventWithdrawalRemoved+":"+mined.ID+":"+*mined.BlockHash,
+		cfg.Webhook.URLs[0], sqlmock.AnyArg(), data.EventPending,
+		sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE mined_hash`).
+		WithArgs(mined.Hash, mined.ID).WillReturnRows(
+		sqlmock.NewRows(mined.Table().Columns()).
+			AddRow(outputRow(replaced)...))
+	sqlMock.ExpectExec(`UPDATE "outputs"`).WithArgs(replaced.Hash,
+		replaced.Account, nil, mined.ID, nil, data.OutputPending, nil, nil,
+		nil, nil, 0, nil, sqlmock.AnyArg(), data.OutputWithdrawal, nil, nil,
+		replaced.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`UPDATE "outputs"`).WithArgs(mined.Hash,
+		mined.Account, nil, nil, nil, data.OutputPending, nil, nil, nil,
+		nil, 0, nil, sqlmock.AnyArg(), data.OutputWithdrawal, nil, nil,
+		mined.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "balances"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(balance.Table().Columns()).
+			AddRow(balanceRow...))
+	sqlMock.ExpectExec(`DELETE FROM "balances"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`DELETE FROM "blocks"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlMock.ExpectExec(`UPDATE "settings"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	accounts := map[common.Address]struct{}{from: {}, holder: {}}
+
+	ancestor, err := proc.CheckReorg(scheduler, ctx, block, accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ancestor == nil || ancestor.Uint64() != 0 {
+		t.Fatalf("expected common ancestor 0, got %v", ancestor)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{strings.ToLower(from.String()),
+		strings.ToLower(holder.String()), balance.Account}
+	got, number := proc.UpdatedAccounts(scheduler)
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected accounts %v, got %v", exp, got)
+	}
+
+	// The balances are read at the common ancestor.
+	if number.Cmp(ancestor) != 0 {
+		t.Fatalf("expected balances at block %s, got %s", ancestor, number)
+	}
+
+	if _, err := client.TransactionReceipt(ctx,
+		common.HexToHash(*hash)); err == nil {
+		t.Fatal("transaction of the orphaned block is still mined")
+	}
+}
+
+// waitExpectations waits until the collector meets the expectations.
+func waitExpectations(t *testing.T, sqlMock sqlmock.Sqlmock) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := sqlMock.ExpectationsWereMet()
+		if err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduler_CollectReorg(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataBase, sqlMock := newDB(t)
+	client, from := newEthClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	cfg := config.NewConfig()
+	// The collector is woken up by the test.
+	cfg.Proc.CollectPause = uint64(time.Hour / time.Millisecond)
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, err := client.BlockByNumber(ctx, big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proc.SetLastBlock(scheduler, big.NewInt(1))
+
+	account := strings.ToLower(from.String())
+	accountColumns := (&data.Account{}).Table().Columns()
+	txColumns := (&data.Transaction{}).Table().Columns()
+	transferColumns := (&data.TokenTransfer{}).Table().Columns()
+	outputColumns := (&data.Output{}).Table().Columns()
+	balanceColumns := (&data.Balance{}).Table().Columns()
+
+	expAccounts := func() {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "accounts"`).
+			WillReturnRows(sqlmock.NewRows(accountColumns))
+	}
+
+	expStoredBlock := func(number uint64, block *types.Block) {
+		rows := sqlmock.NewRows(blockColumns)
+		if block != nil {
+			rows.AddRow(blockRow(block)...)
+		}
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "blocks"`).
+			WithArgs(number).WillReturnRows(rows)
+	}
+
+	expAdvance := func(block *types.Block) {
+		sqlMock.ExpectExec(`UPDATE "blocks"`).WithArgs(
+			block.Hash().String(), block.ParentHash().String(),
+			block.NumberU64()).WillReturnResult(sqlmock.NewResult(0, 0))
+		sqlMock.ExpectQuery(`INSERT INTO "blocks"`).WithArgs(
+			block.NumberU64(), block.Hash().String(),
+			block.ParentHash().String()).WillReturnRows(
+			sqlmock.NewRows([]string{"number"}).AddRow(block.NumberU64()))
+		sqlMock.ExpectExec(`UPDATE "settings"`).WithArgs(
+			strconv.FormatUint(block.NumberU64()+1, 10), "lastBlock").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// The transaction of the first block is collected.
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "settings"`).WillReturnRows(
+		sqlmock.NewRows([]string{"key", "value"}).AddRow("lastBlock", "1"))
+	expAccounts()
+	expStoredBlock(0, nil)
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "transactions"`).WithArgs(
+		sqlmock.AnyArg(), *hash, account, strings.ToLower(to.String()),
+		"1000", data.TxSuccessful, 1, orphaned.Time(), false, 0, nil,
+		21000, 21000, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+		sqlmock.AnyArg(), 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(gen.NewUUID()))
+	expAdvance(orphaned)
+	sqlMock.ExpectCommit()
+
+	collected := make(chan error, 1)
+	go func() {
+		collected <- proc.Collect(scheduler, ctx)
+	}()
+
+	waitExpectations(t, sqlMock)
+
+	got, number := proc.UpdatedAccounts(scheduler)
+	if !reflect.DeepEqual([]string{account}, got) {
+		t.Fatalf("expected accounts %v, got %v", []string{account}, got)
+	}
+
+	if number.Uint64() != 1 {
+		t.Fatalf("expected balances at block 1, got %s", number)
+	}
+
+	// The side chain without the transaction replaces the first block.
+	if err := client.Fork(0); err != nil {
+		t.Fatal(err)
+	}
+	client.Mine()
+	client.Mine()
+
+	side1, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	side2, err := client.BlockByNumber(ctx, big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &data.Transaction{
+		ID:     gen.NewUUID(),
+		Hash:   *hash,
+		From:   account,
+		To:     strings.ToLower(to.String()),
+		Amount: "1000",
+		Status: pointer.ToString(data.TxSuccessful),
+		Block:  pointer.ToUint64(1),
+	}
+
+	var txRow []driver.Value
+	for _, v := range tx.Values() {
+		txRow = append(txRow, v)
+	}
+
+	// The orphaned block is rolled back and the side chain is collected.
+	expAccounts()
+	expStoredBlock(1, orphaned)
+	expStoredBlock(1, orphaned)
+	expStoredBlock(0, genesis)
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "transactions"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(txColumns).AddRow(txRow...))
+	sqlMock.ExpectExec(`DELETE FROM "transactions"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "token_transfers"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(transferColumns))
+	sqlMock.ExpectExec(`DELETE FROM "token_transfers"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(outputColumns))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "balances"`).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(balanceColumns))
+	sqlMock.ExpectExec(`DELETE FROM "balances"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`DELETE FROM "blocks"`).WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`UPDATE "settings"`).WithArgs("1", "lastBlock").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	parent := genesis
+	for _, block := range []*types.Block{side1, side2} {
+		expAccounts()
+		expStoredBlock(parent.NumberU64(), parent)
+		sqlMock.ExpectBegin()
+		expAdvance(block)
+		sqlMock.ExpectCommit()
+		parent = block
+	}
+
+	proc.SetLastBlock(scheduler, big.NewInt(2))
+
+	waitExpectations(t, sqlMock)
+
+	if got, _ := proc.UpdatedAccounts(scheduler); !reflect.DeepEqual(
+		[]string{account}, got) {
+		t.Fatalf("expected accounts %v after the reorganization, got %v",
+			[]string{account}, got)
+	}
+
+	cancel()
+
+	if err := <-collected; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduler_UpdateBalances(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	client, from := newEthClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID,
+		config.NewConfig(), dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.SendTransaction(ctx, from, to, big.NewInt(1000),
+		nil); err != nil {
+		t.Fatal(err)
+	}
+
+	collected, err := client.BlockByNumber(ctx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The balance changes after the collected block.
+	if _, err := client.SendTransaction(ctx, from, to, big.NewInt(2000),
+		nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Mine()
+
+	proc.SetLastBlock(scheduler, big.NewInt(3))
+
+	account := strings.ToLower(to.String())
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "accounts"`).WithArgs(account).
+		WillReturnRows(sqlmock.NewRows(
+			(&data.Account{}).Table().Columns()))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "accounts"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`INSERT INTO "accounts"`).
+		WithArgs(sqlmock.AnyArg(), "1000", account, nil, data.AccountNode,
+			nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+		AddRow(gen.NewUUID()))
+	sqlMock.ExpectExec(`INSERT INTO balances`).WithArgs(sqlmock.AnyArg(),
+		account, 1, collected.Time(), "1000").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	if err := proc.UpdateBalances(scheduler, ctx, []string{account},
+		collected.Number()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduler_Status(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	client, _ := newEthClient(t)
+
+	cfg := config.NewConfig()
+	cfg.Proc.ReadyMaxLag = 2
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		client.Mine()
+	}
+
+	expSelectSQL := `SELECT (.+) FROM "settings"`
+	settingColumns := []string{"key", "value"}
+
+	sqlMock.ExpectQuery(expSelectSQL).WillReturnRows(
+		sqlmock.NewRows(settingColumns).AddRow("lastBlock", "2"))
+
+	status := scheduler.Status(ctx)
+	if !status.Ready || status.Head != 3 || status.LastBlock != 2 ||
+		status.Lag != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	errNode := errors.New("node is down")
+	client.FailNext("SyncProgress", errNode)
+
+	sqlMock.ExpectQuery(expSelectSQL).WillReturnRows(
+		sqlmock.NewRows(settingColumns).AddRow("lastBlock", "1"))
+
+	status = scheduler.Status(ctx)
+	if status.Ready || status.NodeError != errNode.Error() ||
+		len(status.NotReady) != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func outputRow(output *data.Output) (result []driver.Value) {
+	for _, v := range output.Values() {
+		result = append(result, v)
+	}
+	return result
+}
+
+func TestScheduler_CheckOutputs(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	client, from := newEthClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID,
+		config.NewConfig(), dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The transaction has been mined after it was marked as dropped.
+	late, err := client.SendTransaction(ctx, from, to, big.NewInt(2000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Mine()
+
+	proc.SetLastBlock(scheduler, big.NewInt(3))
+
+	account := strings.ToLower(from.String())
+	sentAt := uint64(time.Now().Unix()) - 3600
+
+	mined := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    *hash,
+		Account: account,
+		SentAt:  pointer.ToUint64(sentAt),
+		Status:  data.OutputPending,
+		Kind:    data.OutputWithdrawal,
+		Amount:  pointer.ToString("1000"),
+	}
+
+	promoted := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    *late,
+		Account: account,
+		SentAt:  pointer.ToUint64(sentAt + 1),
+		Status:  data.OutputDropped,
+		Kind:    data.OutputWithdrawal,
+		Amount:  pointer.ToString("2000"),
+	}
+
+	dropped := &data.Output{
+		ID: gen.NewUUID(),
+		Hash: "0x64e604787cbf194841e7b68d7cd28786" +
+			"f6c9a0a3ab9f8b0a0e87cb4387ab0107",
+		Account: account,
+		SentAt:  pointer.ToUint64(sentAt + 2),
+		Status:  data.OutputPending,
+		Kind:    data.OutputWithdrawal,
+	}
+
+	// A dropped output, which has not been mined yet, is not updated.
+	stale := &data.Output{
+		ID: gen.NewUUID(),
+		Hash: "0x0ad2d8a8f8f8e3d8b0c1e1d2b1f2c3a4" +
+			"a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0",
+		Account: account,
+		SentAt:  pointer.ToUint64(sentAt + 3),
+		Status:  data.OutputDropped,
+		Kind:    data.OutputWithdrawal,
+	}
+
+	columns := mined.Table().Columns()
+
+	// Outputs are checked in the order they were sent.
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE status IN \(\$1, \$2\) ORDER BY sent_at, id$`).
+		WithArgs(data.OutputPending, data.OutputDropped).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow(outputRow(mined)...).
+			AddRow(outputRow(promoted)...).
+			AddRow(outputRow(dropped)...).
+			AddRow(outputRow(stale)...))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "outputs"`).WithArgs(mined.Hash, account,
+		sentAt, nil, mined.Hash, data.OutputSuccessful, 1,
+		sqlmock.AnyArg(), 21000, sqlmock.AnyArg(), 2, sqlmock.AnyArg(), nil,
+		data.OutputWithdrawal, *mined.Amount, 0, mined.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "outputs"`).WithArgs(promoted.Hash, account,
+		sentAt+1, nil, promoted.Hash, data.OutputSuccessful, 2,
+		sqlmock.AnyArg(), 21000, sqlmock.AnyArg(), 1, sqlmock.AnyArg(), nil,
+		data.OutputWithdrawal, *promoted.Amount, 1, promoted.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "outputs"`).WithArgs(dropped.Hash, account,
+		sentAt+2, nil, nil, data.OutputDropped, nil, nil, nil, nil, 0, nil,
+		nil, data.OutputWithdrawal, nil, nil, dropped.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE status IN \(\$1, \$2\) AND confirmations`).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	if err := proc.CheckOutputs(scheduler, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduler_SpeedUp(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	client, from := newEthClient(t)
+	to := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	client.AutoMine = false
+
+	hash, err := client.SendTransaction(ctx, from, to, big.NewInt(1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, _, err := client.TransactionByHash(ctx,
+		common.HexToHash(*hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cap allows only the minimal fee bump.
+	maxFee := new(big.Int).Div(new(big.Int).Mul(original.GasPrice(),
+		big.NewInt(110)), big.NewInt(100))
+
+	cfg := config.NewConfig()
+	cfg.Proc.SpeedUpAfter = 60
+	cfg.Proc.SpeedUpMaxFeePerGas = maxFee
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proc.SetLastBlock(scheduler, big.NewInt(0))
+
+	output := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    *hash,
+		Account: strings.ToLower(from.String()),
+		SentAt:  pointer.ToUint64(uint64(time.Now().Unix()) - 3600),
+		Status:  data.OutputPending,
+		Kind:    data.OutputWithdrawal,
+		Amount:  pointer.ToString("1000"),
+	}
+
+	columns := output.Table().Columns()
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE status IN \(\$1, \$2\) ORDER BY`).
+		WithArgs(data.OutputPending, data.OutputDropped).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow(outputRow(output)...))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "outputs"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	// The lease is held in the outer transaction.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE "outputs"."hash" = \$1`).
+		WithArgs(output.Hash).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow(outputRow(output)...))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE hash = \$1 FOR UPDATE`).
+		WithArgs(output.Hash).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow(outputRow(output)...))
+	sqlMock.ExpectQuery(`INSERT INTO "outputs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(gen.NewUUID()))
+	sqlMock.ExpectExec(`UPDATE "outputs"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs" WHERE status IN`).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	if err := proc.CheckOutputs(scheduler, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	block := client.Mine()
+	if len(block.Transactions()) != 1 {
+		t.Fatalf("expected 1 transaction, got %d",
+			len(block.Transactions()))
+	}
+
+	replacement := block.Transactions()[0]
+	if replacement.Hash() == original.Hash() ||
+		replacement.Nonce() != original.Nonce() ||
+		replacement.GasPrice().Cmp(maxFee) != 0 {
+		t.Fatalf("unexpected replacement: nonce %d, gas price %s",
+			replacement.Nonce(), replacement.GasPrice())
+	}
+}
+
+func TestGetTokenTransfers(t *testing.T) {
+	token := common.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+	managed := common.HexToAddress("0x3e1f7a52f6b3b8a4e0e2f5cfa1d5b1c3e5b5d7a9")
+	foreign := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+	other := common.HexToAddress("0x5aeda56215b167893e80b4fe645ba6d5bab767de")
+
+	accounts := map[common.Address]struct{}{managed: {}}
+
+	topic := func(addr common.Address) common.Hash {
+		return common.BytesToHash(addr.Bytes())
+	}
+	amount := common.BigToHash(big.NewInt(1000)).Bytes()
+	txHash := common.HexToHash("0x64e604787cbf194841e7b68d7cd28786" +
+		"f6c9a0a3ab9f8b0a0e87cb4387ab0107")
+
+	tests := []struct {
+		name string
+		log  types.Log
+		exp  bool
+	}{
+		{
+			name: "ERC-20 transfer to a managed account",
+			log: types.Log{
+				Topics: []common.Hash{proc.TransferEventID,
+					topic(foreign), topic(managed)},
+				Data: amount,
+			},
+			exp: true,
+		},
+		{
+			name: "ERC-721 transfer",
+			log: types.Log{
+				Topics: []common.Hash{proc.TransferEventID,
+					topic(foreign), topic(managed),
+					common.BigToHash(big.NewInt(1))},
+			},
+		},
+		{
+			name: "data is not one word",
+			log: types.Log{
+				Topics: []common.Hash{proc.TransferEventID,
+					topic(foreign), topic(managed)},
+				Data: append(amount, amount...),
+			},
+		},
+		{
+			name: "transfer between accounts, which are not managed",
+			log: types.Log{
+				Topics: []common.Hash{proc.TransferEventID,
+					topic(foreign), topic(other)},
+				Data: amount,
+			},
+		},
+		{
+			name: "removed log",
+			log: types.Log{
+				Topics: []common.Hash{proc.TransferEventID,
+					topic(foreign), topic(managed)},
+				Data:    amount,
+				Removed: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		l := test.log
+		l.Address = token
+		l.TxHash = txHash
+		l.Index = 3
+
+		transfers := proc.GetTokenTransfers([]*types.Log{&l}, accounts,
+			big.NewInt(7), 1660000000, 2)
+
+		if !test.exp {
+			if len(transfers) != 0 {
+				t.Errorf("%s: expected no transfers, got %+v",
+					test.name, transfers[0])
+			}
+			continue
+		}
+
+		if len(transfers) != 1 {
+			t.Errorf("%s: expected one transfer, got %d",
+				test.name, len(transfers))
+			continue
+		}
+
+		exp := &data.TokenTransfer{
+			ID:            transfers[0].ID,
+			Hash:          txHash.String(),
+			Token:         strings.ToLower(token.String()),
+			LogIndex:      3,
+			From:          strings.ToLower(foreign.String()),
+			To:            strings.ToLower(managed.String()),
+			Amount:        "1000",
+			Block:         pointer.ToUint64(7),
+			Timestamp:     pointer.ToUint64(1660000000),
+			Confirmations: 2,
+		}
+		if !reflect.DeepEqual(transfers[0], exp) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, exp,
+				transfers[0])
+		}
+	}
+}
+
+func TestSetFees(t *testing.T) {
+	gwei := big.NewInt(1e9)
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   eth.MockChainID,
+		Gas:       25200,
+		GasTipCap: new(big.Int).Mul(big.NewInt(2), gwei),
+		GasFeeCap: new(big.Int).Mul(big.NewInt(100), gwei),
+	})
+	receipt := &types.Receipt{GasUsed: 21000}
+
+	local := &data.Transaction{}
+	proc.SetFees(local, tx, receipt, new(big.Int).Mul(big.NewInt(10), gwei))
+
+	exp := &data.Transaction{
+		GasLimit:          pointer.ToUint64(25200),
+		GasUsed:           pointer.ToUint64(21000),
+		EffectiveGasPrice: pointer.ToString("12000000000"),
+		Fee:               pointer.ToString("252000000000000"),
+		BurnedFee:         pointer.ToString("210000000000000"),
+		TipFee:            pointer.ToString("42000000000000"),
+		TxType:            pointer.ToUint64(types.DynamicFeeTxType),
+	}
+
+	if !reflect.DeepEqual(local, exp) {
+		t.Fatalf("expected %+v, got %+v", exp, local)
+	}
+}
+
+func TestScheduler_SweepAccounts(t *testing.T) {
+	ctx := context.Background()
+	dataBase, sqlMock := newDB(t)
+	client, from := newEthClient(t)
+	cold := common.HexToAddress("0xa7dba6053a0d631177340e8061bc12f5009ba453")
+
+	cfg := config.NewConfig()
+	cfg.Sweep.ColdAddress = cold.String()
+	cfg.Sweep.MinBalance = big.NewInt(1e15)
+	cfg.Sweep.Reserve = big.NewInt(1e16)
+	cfg.Sweep.MaxFeePerGas = big.NewInt(1e10)
+
+	scheduler, err := proc.NewScheduler(ctx, eth.MockChainID, cfg,
+		dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.SetNonceManager(eth.NewNonceManager(dataBase, client))
+
+	// The transaction uses the nonce 0.
+	if _, err := client.SendTransaction(ctx, from, cold, big.NewInt(1000),
+		nil); err != nil {
+		t.Fatal(err)
+	}
+
+	initial, err := client.BalanceAt(ctx, from, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := strings.ToLower(from.String())
+	newOutput := func(hash, status string, nonce *uint64,
+		amount string) *data.Output {
+		return &data.Output{
+			ID:      gen.NewUUID(),
+			Hash:    hash,
+			Account: account,
+			Status:  status,
+			Kind:    data.OutputWithdrawal,
+			Amount:  pointer.ToString(amount),
+			Nonce:   nonce,
+		}
+	}
+
+	pending := newOutput("0x64e604787cbf194841e7b68d7cd28786"+
+		"f6c9a0a3ab9f8b0a0e87cb4387ab0107", data.OutputPending, nil, "1000")
+	// The nonce of the dropped output has been used,
+	// so it can not be mined anymore.
+	used := newOutput("0x7d3a1ec2bd48e5b7e3b6c7f0fa3e0cfb"+
+		"5c1d35cf2b9e46e1b7a32c6a2b0e1f7d", data.OutputDropped,
+		pointer.ToUint64(0), "5000")
+	dropped := newOutput("0x1f6a2b0e3c5d7e9f1a2b3c4d5e6f7a8b"+
+		"9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f", data.OutputDropped,
+		pointer.ToUint64(1), "2000")
+	unknown := newOutput("0x2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d"+
+		"8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b", data.OutputDropped, nil,
+		"3000")
+
+	// Balance without the pending output, the dropped outputs, which
+	// can still be mined, the reserve and the fee.
+	exp := new(big.Int).Sub(initial,
+		big.NewInt(1e16+1000+2000+3000+1e10*25200))
+
+	sqlMock.ExpectQuery(`SELECT COUNT\(\*\) FROM "transactions"`).
+		WithArgs(account, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	// The reserved funds are read and the sweep is stored
+	// holding the nonce lock.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`INSERT INTO nonces`).WithArgs(account).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "nonces" (.+) FOR UPDATE`).
+		WithArgs(account).WillReturnRows(
+		sqlmock.NewRows([]string{"account", "next"}).AddRow(account, 0))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "outputs"`).
+		WithArgs(account, data.OutputPending, data.OutputDropped).
+		WillReturnRows(sqlmock.NewRows(pending.Table().Columns()).
+			AddRow(outputRow(pending)...).AddRow(outputRow(used)...).
+			AddRow(outputRow(dropped)...).AddRow(outputRow(unknown)...))
+	sqlMock.ExpectQuery(`INSERT INTO "outputs"`).WithArgs(sqlmock.AnyArg(),
+		sqlmock.AnyArg(), account, sqlmock.AnyArg(), nil, nil, data.OutputPending, nil, nil,
+		nil, nil, 0, nil, nil, data.OutputSweep, exp.String(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(gen.NewUUID()))
+	sqlMock.ExpectCommit()
+
+	if err := proc.SweepAccounts(scheduler, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := client.BalanceAt(ctx, cold, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Sub(balance, big.NewInt(1000)).Cmp(exp) != 0 {
+		t.Fatalf("expected swept %s, got %s", exp, balance)
+	}
 
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,126 @@
+package proc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Scheduler tasks.
+const (
+	TaskUpdateLastBlock    = "updateLastBlock"
+	TaskCollect            = "collect"
+	TaskUpdateTransactions = "updateTransactions"
+	TaskUpdateAccounts     = "updateAccounts"
+	TaskUpdateOutputs      = "updateOutputs"
+	TaskWebhooks           = "webhooks"
+	TaskSweep              = "sweep"
+)
+
+// TaskError is the last error of a scheduler task.
+type TaskError struct {
+	Error string
+	Time  time.Time
+}
+
+// taskFailed records the last error of the task.
+func (s *Scheduler) taskFailed(task string, err error) {
+	s.errMtx.Lock()
+	defer s.errMtx.Unlock()
+
+	s.taskErrs[task] = TaskError{Error: err.Error(), Time: time.Now()}
+}
+
+// TaskErrors returns the last errors of the scheduler tasks.
+// Tasks without errors are omitted.
+func (s *Scheduler) TaskErrors() map[string]TaskError {
+	s.errMtx.Lock()
+	defer s.errMtx.Unlock()
+
+	result := make(map[string]TaskError, len(s.taskErrs))
+	for task, err := range s.taskErrs {
+		result[task] = err
+	}
+	return result
+}
+
+// SyncStatus is a sync progress of the node.
+type SyncStatus struct {
+	StartingBlock uint64
+	CurrentBlock  uint64
+	HighestBlock  uint64
+}
+
+// Status is a state of the node and of the scheduler.
+type Status struct {
+	// Ready is true if the node is synced, the database is reachable
+	// and the collector lag does not exceed Proc.ReadyMaxLag.
+	Ready bool
+	// NotReady contains the reasons why the service is not ready.
+	NotReady []string
+	// Sync is nil if the node is synced.
+	Sync      *SyncStatus
+	NodeError string
+	// Head is the last block of the node.
+	Head uint64
+	// LastBlock is the next block to be collected.
+	LastBlock uint64
+	// Lag is a number of blocks which are not collected yet.
+	Lag     uint64
+	DBError string
+	// Leader is true if the replica runs the tasks.
+	Leader bool
+	Tasks  []TaskStatus
+}
+
+// Status returns the current state of the node and of the scheduler.
+func (s *Scheduler) Status(ctx context.Context) *Status {
+	status := &Status{Leader: s.Leader(), Tasks: s.Tasks()}
+
+	progress, err := s.eth.SyncProgress(ctx)
+	if err == nil && progress != nil {
+		status.Sync = &SyncStatus{
+			StartingBlock: progress.StartingBlock,
+			CurrentBlock:  progress.CurrentBlock,
+			HighestBlock:  progress.HighestBlock,
+		}
+		status.NotReady = append(status.NotReady, "node is syncing")
+	}
+
+	var head *types.Block
+	if err == nil {
+		head, err = s.eth.BlockByNumber(ctx, nil)
+	}
+
+	if err != nil {
+		status.NodeError = err.Error()
+		status.NotReady = append(status.NotReady,
+			"node is unavailable: "+err.Error())
+	} else {
+		status.Head = head.NumberU64()
+	}
+
+	start, err := s.startBlock()
+	if err != nil {
+		status.DBError = err.Error()
+		status.NotReady = append(status.NotReady,
+			"database is unavailable: "+err.Error())
+	} else {
+		status.LastBlock = start.Uint64()
+	}
+
+	if head != nil && start != nil && status.Head+1 > status.LastBlock {
+		status.Lag = status.Head + 1 - status.LastBlock
+	}
+
+	if status.Lag > s.cfg.Proc.ReadyMaxLag {
+		status.NotReady = append(status.NotReady,
+			fmt.Sprintf("collector is %d blocks behind", status.Lag))
+	}
+
+	status.Ready = len(status.NotReady) == 0
+
+	return status
+}
@@ -0,0 +1,234 @@
+package proc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/gen"
+)
+
+// checkSweepConfig checks the sweep policy, if sweeping is enabled.
+func checkSweepConfig(cfg *config.Sweep) error {
+	if cfg.ColdAddress == "" {
+		return nil
+	}
+
+	if !common.IsHexAddress(cfg.ColdAddress) {
+		return errors.New("invalid Sweep.ColdAddress")
+	}
+
+	if cfg.MaxFeePerGas == nil || cfg.MaxFeePerGas.Sign() <= 0 {
+		return errors.New("Sweep.MaxFeePerGas is required")
+	}
+
+	return nil
+}
+
+// sweepAccounts sends the excess funds of node accounts
+// to the cold address.
+func (s *Scheduler) sweepAccounts(ctx context.Context) error {
+	accounts, err := s.eth.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	cold := common.HexToAddress(s.cfg.Sweep.ColdAddress)
+
+	for _, account := range accounts {
+		addr := common.HexToAddress(account)
+		if addr == cold {
+			continue
+		}
+
+		// A locked account must not prevent sweeping the others.
+		err := s.sweepAccount(ctx, addr)
+		if err == ErrNotLeader {
+			return err
+		}
+		if err != nil {
+			log.Printf("failed to sweep account %s: %s",
+				strings.ToLower(addr.String()), err)
+			s.taskFailed(TaskSweep, err)
+		}
+	}
+
+	return nil
+}
+
+// sweepAccount sends the excess funds of an account to the cold address,
+// if all its deposits are final. The excess is the balance without
+// the funds reserved for pending outputs, the reserve and the fee.
+func (s *Scheduler) sweepAccount(ctx context.Context,
+	addr common.Address) error {
+	account := strings.ToLower(addr.String())
+
+	final, err := s.depositsFinal(account)
+	if err != nil || !final {
+		return err
+	}
+
+	// Other outputs of the account are not sent, until the sweep
+	// is stored, so the reserved funds do not change.
+	return s.lockNonce(ctx, addr,
+		func(ctx context.Context, q *reform.Querier) error {
+			return s.sweep(ctx, q, addr)
+		})
+}
+
+// lockNonce runs the function holding the nonce lock of the account.
+// Without a nonce manager it runs the function in a database transaction.
+func (s *Scheduler) lockNonce(ctx context.Context, account common.Address,
+	fn func(ctx context.Context, q *reform.Querier) error) error {
+	return eth.LockNonce(ctx, s.nonces, s.db, account, fn)
+}
+
+// sweep sends the excess funds of the account and stores the output.
+func (s *Scheduler) sweep(ctx context.Context, q *reform.Querier,
+	addr common.Address) error {
+	account := strings.ToLower(addr.String())
+
+	// The lease is checked before the broadcast and held
+	// until the sweep is stored.
+	if err := s.checkLease(q); err != nil {
+		return err
+	}
+
+	balance, err := s.eth.BalanceAt(ctx, addr, nil)
+	if err != nil {
+		return err
+	}
+
+	reserved, err := s.reserved(ctx, q, account)
+	if err != nil {
+		return err
+	}
+
+	spendable := new(big.Int).Sub(balance, reserved)
+	if s.cfg.Sweep.Reserve != nil {
+		spendable.Sub(spendable, s.cfg.Sweep.Reserve)
+	}
+
+	if s.cfg.Sweep.MinBalance != nil &&
+		spendable.Cmp(s.cfg.Sweep.MinBalance) < 0 {
+		return nil
+	}
+
+	fee := new(big.Int).Mul(s.cfg.Sweep.MaxFeePerGas,
+		new(big.Int).SetUint64(eth.TransferGas(s.cfg.Eth)))
+	amount := spendable.Sub(spendable, fee)
+	if amount.Sign() <= 0 {
+		return nil
+	}
+
+	hash, err := s.eth.SendTransaction(ctx, addr,
+		common.HexToAddress(s.cfg.Sweep.ColdAddress), amount,
+		&eth.FeeCaps{MaxFeePerGas: s.cfg.Sweep.MaxFeePerGas})
+	if err != nil {
+		return err
+	}
+
+	output := &data.Output{
+		ID:      gen.NewUUID(),
+		Hash:    strings.ToLower(*hash),
+		Account: account,
+		SentAt:  pointer.ToUint64(uint64(time.Now().Unix())),
+		Status:  data.OutputPending,
+		Kind:    data.OutputSweep,
+		Amount:  pointer.ToString(amount.String()),
+	}
+
+	if err := q.Insert(output); err != nil {
+		return err
+	}
+
+	log.Printf("swept %s Wei from %s, transaction: %s", amount,
+		account, output.Hash)
+	return nil
+}
+
+// depositsFinal returns true if all deposits to the account are final.
+func (s *Scheduler) depositsFinal(account string) (bool, error) {
+	notFinal, args := s.finality.NotFinal(s.db.Placeholder, 2, false)
+
+	count, err := s.db.Count(data.TransactionTable,
+		fmt.Sprintf(`WHERE "to" = %s AND %s`, s.db.Placeholder(1),
+			notFinal), append([]interface{}{account}, args...)...)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// reserved returns funds of the account reserved for pending outputs:
+// the amounts and the maximum fees of the transactions. A dropped
+// transaction can still be mined, so dropped outputs are reserved until
+// their nonce is used by a mined transaction. Dropped outputs with
+// an unknown nonce stay reserved.
+func (s *Scheduler) reserved(ctx context.Context, q *reform.Querier,
+	account string) (*big.Int, error) {
+	items, err := q.SelectAllFrom(data.OutputTable,
+		"WHERE account = $1 AND status IN ($2, $3)", account,
+		data.OutputPending, data.OutputDropped)
+	if err != nil {
+		return nil, err
+	}
+
+	var confirmed *uint64
+
+	result := new(big.Int)
+	for k := range items {
+		output := items[k].(*data.Output)
+
+		if output.Status == data.OutputDropped && output.Nonce != nil {
+			if confirmed == nil {
+				nonce, err := s.eth.NonceAt(ctx,
+					common.HexToAddress(account), nil)
+				if err != nil {
+					return nil, err
+				}
+				confirmed = &nonce
+			}
+
+			if *output.Nonce < *confirmed {
+				continue
+			}
+		}
+
+		tx, pending, err := s.eth.TransactionByHash(ctx,
+			common.HexToHash(output.Hash))
+		if err != nil && err != ethereum.NotFound {
+			return nil, err
+		}
+
+		if err == nil {
+			if pending {
+				result.Add(result, tx.Cost())
+			}
+			continue
+		}
+
+		// The transaction is not in the mempool of this node,
+		// but it can be mined yet.
+		if output.Amount != nil {
+			amount, ok := new(big.Int).SetString(*output.Amount, 10)
+			if ok {
+				result.Add(result, amount)
+			}
+		}
+	}
+
+	return result, nil
+}
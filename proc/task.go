@@ -0,0 +1,246 @@
+package proc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Errors of task management.
+var (
+	ErrTaskExists     = errors.New("task already exists")
+	ErrTaskNotFound   = errors.New("task not found")
+	ErrTaskRunning    = errors.New("task is already running")
+	ErrTaskNotRunning = errors.New("task is not running")
+	ErrNotLeader      = errors.New("replica is not the leader")
+)
+
+// Task is a background job of the scheduler. The scheduler runs it
+// repeatedly with the interval between runs until it is stopped.
+type Task interface {
+	// Name returns a unique name of the task.
+	Name() string
+	// Interval returns a pause between runs.
+	Interval() time.Duration
+	// Run runs the task once. A long-running task must return
+	// when the context is done.
+	Run(ctx context.Context) error
+}
+
+type funcTask struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// NewTask creates a task from a function.
+func NewTask(name string, interval time.Duration,
+	run func(ctx context.Context) error) Task {
+	return &funcTask{name: name, interval: interval, run: run}
+}
+
+func (t *funcTask) Name() string {
+	return t.name
+}
+
+func (t *funcTask) Interval() time.Duration {
+	return t.interval
+}
+
+func (t *funcTask) Run(ctx context.Context) error {
+	return t.run(ctx)
+}
+
+// TaskStatus is a state of a scheduler task.
+type TaskStatus struct {
+	Name     string
+	Interval uint64 // In milliseconds.
+	Running  bool
+	// Runs is a number of completed runs since the start.
+	Runs      uint64
+	LastRun   *time.Time
+	LastError *TaskError
+}
+
+type taskEntry struct {
+	task    Task
+	cancel  context.CancelFunc
+	done    chan struct{}
+	runs    uint64
+	lastRun *time.Time
+	// stopped is true if the task is stopped by StopTask,
+	// such a task is not started with the other tasks.
+	stopped bool
+}
+
+// Register adds a task. If the scheduler is started and runs tasks,
+// the task is started at once.
+func (s *Scheduler) Register(task Task) error {
+	s.tasksMtx.Lock()
+	defer s.tasksMtx.Unlock()
+
+	if _, ok := s.tasks[task.Name()]; ok {
+		return errors.Wrap(ErrTaskExists, task.Name())
+	}
+
+	s.tasks[task.Name()] = &taskEntry{task: task}
+	s.taskNames = append(s.taskNames, task.Name())
+
+	if s.started && s.leading {
+		s.startTask(s.tasks[task.Name()])
+	}
+	return nil
+}
+
+// StartTask starts a stopped task. Only the leader runs tasks.
+func (s *Scheduler) StartTask(name string) error {
+	s.tasksMtx.Lock()
+	defer s.tasksMtx.Unlock()
+
+	entry, ok := s.tasks[name]
+	if !ok {
+		return errors.Wrap(ErrTaskNotFound, name)
+	}
+
+	if !s.leading {
+		return ErrNotLeader
+	}
+
+	if entry.cancel != nil {
+		return errors.Wrap(ErrTaskRunning, name)
+	}
+
+	entry.stopped = false
+	s.startTask(entry)
+	return nil
+}
+
+// StopTask stops a task and waits until its current run returns.
+func (s *Scheduler) StopTask(name string) error {
+	s.tasksMtx.Lock()
+	entry, ok := s.tasks[name]
+	if !ok {
+		s.tasksMtx.Unlock()
+		return errors.Wrap(ErrTaskNotFound, name)
+	}
+
+	if entry.cancel == nil {
+		s.tasksMtx.Unlock()
+		return errors.Wrap(ErrTaskNotRunning, name)
+	}
+
+	entry.stopped = true
+	s.tasksMtx.Unlock()
+
+	s.stopTasks([]*taskEntry{entry})
+	return nil
+}
+
+// Tasks returns states of the registered tasks in order of registration.
+func (s *Scheduler) Tasks() []TaskStatus {
+	errs := s.TaskErrors()
+
+	s.tasksMtx.Lock()
+	defer s.tasksMtx.Unlock()
+
+	result := make([]TaskStatus, len(s.taskNames))
+	for k, name := range s.taskNames {
+		entry := s.tasks[name]
+		result[k] = TaskStatus{
+			Name:     name,
+			Interval: uint64(entry.task.Interval() / time.Millisecond),
+			Running:  entry.cancel != nil,
+			Runs:     entry.runs,
+			LastRun:  entry.lastRun,
+		}
+
+		if err, ok := errs[name]; ok {
+			result[k].LastError = &err
+		}
+	}
+	return result
+}
+
+// startTasks starts the tasks, which are not running and are not
+// stopped by StopTask, tasksMtx must be locked.
+func (s *Scheduler) startTasks() {
+	for _, name := range s.taskNames {
+		entry := s.tasks[name]
+		if entry.cancel == nil && !entry.stopped {
+			s.startTask(entry)
+		}
+	}
+}
+
+// stopTasks stops the tasks and waits until their current runs return,
+// tasksMtx must not be locked.
+func (s *Scheduler) stopTasks(entries []*taskEntry) {
+	s.tasksMtx.Lock()
+	dones := make([]chan struct{}, len(entries))
+	for k, entry := range entries {
+		if entry.cancel != nil {
+			entry.cancel()
+		}
+		dones[k] = entry.done
+	}
+	s.tasksMtx.Unlock()
+
+	for _, done := range dones {
+		if done != nil {
+			<-done
+		}
+	}
+
+	// A task is considered running until its run returns,
+	// so that it is not started twice.
+	s.tasksMtx.Lock()
+	for k, entry := range entries {
+		if entry.done == dones[k] {
+			entry.cancel = nil
+		}
+	}
+	s.tasksMtx.Unlock()
+}
+
+// startTask starts a task, tasksMtx must be locked.
+func (s *Scheduler) startTask(entry *taskEntry) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	entry.cancel = cancel
+	entry.done = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.runTask(ctx, entry, entry.done)
+}
+
+// runTask runs a task with the interval until the context is done.
+func (s *Scheduler) runTask(ctx context.Context, entry *taskEntry,
+	done chan struct{}) {
+	defer s.wg.Done()
+	defer close(done)
+
+	name := entry.task.Name()
+
+	for {
+		err := entry.task.Run(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("task %s failed: %s", name, err)
+			s.taskFailed(name, err)
+		}
+
+		now := time.Now()
+		s.tasksMtx.Lock()
+		entry.runs++
+		entry.lastRun = &now
+		s.tasksMtx.Unlock()
+
+		timer := time.NewTimer(entry.task.Interval())
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package proc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/proc"
+)
+
+func taskStatus(t *testing.T, scheduler *proc.Scheduler,
+	name string) proc.TaskStatus {
+	for _, status := range scheduler.Tasks() {
+		if status.Name == name {
+			return status
+		}
+	}
+
+	t.Fatalf("task %s not found", name)
+	return proc.TaskStatus{}
+}
+
+func TestScheduler_Tasks(t *testing.T) {
+	dataBase, _ := newDB(t)
+	client, _ := newEthClient(t)
+
+	scheduler, err := proc.NewScheduler(context.Background(),
+		eth.MockChainID, config.NewConfig(), dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Close()
+
+	const name = "test"
+	errTask := errors.New("task failed")
+	runs := make(chan struct{})
+
+	task := proc.NewTask(name, time.Millisecond,
+		func(ctx context.Context) error {
+			select {
+			case runs <- struct{}{}:
+			case <-ctx.Done():
+			}
+			return errTask
+		})
+
+	if err := scheduler.Register(task); err != nil {
+		t.Fatal(err)
+	}
+
+	err = scheduler.Register(task)
+	if !errors.Is(err, proc.ErrTaskExists) {
+		t.Fatalf("expected %v, got %v", proc.ErrTaskExists, err)
+	}
+
+	err = scheduler.StartTask("unknown")
+	if !errors.Is(err, proc.ErrTaskNotFound) {
+		t.Fatalf("expected %v, got %v", proc.ErrTaskNotFound, err)
+	}
+
+	if status := taskStatus(t, scheduler, name); status.Running {
+		t.Fatal("task is running before the start")
+	}
+
+	if err := scheduler.StartTask(name); err != nil {
+		t.Fatal(err)
+	}
+
+	<-runs
+	<-runs
+
+	err = scheduler.StartTask(name)
+	if !errors.Is(err, proc.ErrTaskRunning) {
+		t.Fatalf("expected %v, got %v", proc.ErrTaskRunning, err)
+	}
+
+	if err := scheduler.StopTask(name); err != nil {
+		t.Fatal(err)
+	}
+
+	status := taskStatus(t, scheduler, name)
+	if status.Running || status.Runs < 2 || status.LastRun == nil {
+		t.Fatalf("unexpected task status: %+v", status)
+	}
+
+	if status.LastError == nil || status.LastError.Error != errTask.Error() {
+		t.Fatalf("unexpected task error: %+v", status.LastError)
+	}
+
+	err = scheduler.StopTask(name)
+	if !errors.Is(err, proc.ErrTaskNotRunning) {
+		t.Fatalf("expected %v, got %v", proc.ErrTaskNotRunning, err)
+	}
+}
@@ -0,0 +1,302 @@
+package proc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"gopkg.in/reform.v1"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/gen"
+)
+
+// Webhook event types.
+const (
+	EventDepositSeen      = "deposit.seen"
+	EventDepositConfirmed = "deposit.confirmed"
+	// EventDepositRemoved is sent when a deposit is removed
+	// by a chain reorganization.
+	EventDepositRemoved  = "deposit.removed"
+	EventWithdrawalMined = "withdrawal.mined"
+	// EventWithdrawalFailed is sent when a withdrawal is mined,
+	// but reverted.
+	EventWithdrawalFailed  = "withdrawal.failed"
+	EventWithdrawalDropped = "withdrawal.dropped"
+	// EventWithdrawalRemoved is sent when a mined withdrawal is removed
+	// by a chain reorganization.
+	EventWithdrawalRemoved = "withdrawal.removed"
+)
+
+// Webhook request headers.
+const (
+	HeaderEventType = "X-Webhook-Event"
+	HeaderSignature = "X-Webhook-Signature"
+	// HeaderTimestamp is a Unix time of the delivery attempt,
+	// it is signed together with the body.
+	HeaderTimestamp = "X-Webhook-Timestamp"
+)
+
+// webhookBatch is a maximum number of events delivered at once.
+const webhookBatch = 100
+
+// webhookBody is a body of a webhook request.
+type webhookBody struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt uint64      `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// depositData is data of deposit events.
+type depositData struct {
+	*data.Transaction
+	// Threshold is the reached confirmation threshold.
+	Threshold uint64 `json:"threshold,omitempty"`
+	// Final is true if the deposit has enough confirmations
+	// by Proc.Confirmations and Proc.ConfirmationTiers.
+	Final bool `json:"final"`
+}
+
+// newDepositData returns data of a deposit event, threshold is zero
+// for events other than deposit.confirmed.
+func (s *Scheduler) newDepositData(tx *data.Transaction,
+	threshold uint64) *depositData {
+	return &depositData{
+		Transaction: tx,
+		Threshold:   threshold,
+		Final:       s.finality.Final("", tx.Amount, tx.Confirmations),
+	}
+}
+
+// checkWebhookConfig checks the webhook configuration.
+func checkWebhookConfig(cfg *config.Webhook) error {
+	if len(cfg.URLs) != 0 && cfg.Secret == "" {
+		return errors.New("Webhook.Secret is required")
+	}
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 signature of a webhook body sent at the
+// Unix time in the format of the signature header. The signed message
+// is the timestamp and the body joined by a dot, so that a captured
+// request cannot be replayed later.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// enqueueEvent queues an event for delivery to every endpoint. An event
+// with the same key is queued only once.
+func (s *Scheduler) enqueueEvent(q *reform.Querier, eventType, key string,
+	payload interface{}) error {
+	if len(s.cfg.Webhook.URLs) == 0 {
+		return nil
+	}
+
+	now := uint64(time.Now().Unix())
+
+	body, err := json.Marshal(&webhookBody{
+		ID:        gen.NewUUID(),
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, url := range s.cfg.Webhook.URLs {
+		if _, err := q.Exec(`INSERT INTO webhook_events
+			(id, type, key, url, payload, status, next_attempt_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			ON CONFLICT (key, url) DO NOTHING`, gen.NewUUID(), eventType,
+			key, url, string(body), data.EventPending, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isDeposit returns true if the transaction is a successful transfer
+// to one of the accounts.
+func isDeposit(tx *data.Transaction,
+	accounts map[common.Address]struct{}) bool {
+	if tx.Status == nil || *tx.Status != data.TxSuccessful {
+		return false
+	}
+
+	_, ok := accounts[common.HexToAddress(tx.To)]
+	return ok
+}
+
+// depositEvents queues events of the confirmation thresholds reached
+// by a deposit since it had the previous number of confirmations.
+// If seen is true, the deposit has just been collected.
+func (s *Scheduler) depositEvents(q *reform.Querier, tx *data.Transaction,
+	seen bool, prev uint64) error {
+	if seen {
+		if err := s.enqueueEvent(q, EventDepositSeen,
+			EventDepositSeen+":"+tx.ID,
+			s.newDepositData(tx, 0)); err != nil {
+			return err
+		}
+	}
+
+	for _, threshold := range s.cfg.Webhook.Confirmations {
+		if threshold <= prev || threshold > tx.Confirmations {
+			continue
+		}
+
+		if err := s.enqueueEvent(q, EventDepositConfirmed,
+			fmt.Sprintf("%s:%s:%d", EventDepositConfirmed, tx.ID,
+				threshold), s.newDepositData(tx, threshold)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withdrawalEvent queues an event of a mined, failed or dropped output.
+func (s *Scheduler) withdrawalEvent(q *reform.Querier,
+	output *data.Output) error {
+	var eventType string
+	switch output.Status {
+	case data.OutputSuccessful:
+		eventType = EventWithdrawalMined
+	case data.OutputFailed:
+		eventType = EventWithdrawalFailed
+	case data.OutputDropped:
+		eventType = EventWithdrawalDropped
+	default:
+		return nil
+	}
+
+	return s.enqueueEvent(q, eventType,
+		withdrawalEventKey(eventType, output), output)
+}
+
+// withdrawalEventKey returns a key of a withdrawal event. Events of
+// a mined output are bound to the block, so that the output mined again
+// after a chain reorganization gets new events.
+func withdrawalEventKey(eventType string, output *data.Output) string {
+	key := eventType + ":" + output.ID
+	if output.BlockHash != nil {
+		key += ":" + *output.BlockHash
+	}
+	return key
+}
+
+// deliverEvents delivers queued events, whose next attempt is due.
+// A failed event is retried with exponential backoff until it is
+// marked as dead.
+func (s *Scheduler) deliverEvents(ctx context.Context) error {
+	items, err := s.db.SelectAllFrom(data.WebhookEventTable,
+		"WHERE status = $1 AND next_attempt_at <= $2"+
+			" ORDER BY created_at LIMIT $3", data.EventPending,
+		time.Now().Unix(), webhookBatch)
+	if err != nil {
+		return err
+	}
+
+	for k := range items {
+		event := items[k].(*data.WebhookEvent)
+
+		err := s.deliver(ctx, event)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		now := uint64(time.Now().Unix())
+
+		if err == nil {
+			event.Status = data.EventDelivered
+			event.DeliveredAt = pointer.ToUint64(now)
+		} else {
+			event.Attempts++
+			event.LastError = pointer.ToString(err.Error())
+			event.NextAttemptAt = now + s.webhookBackoff(event.Attempts)
+
+			if event.Attempts >= s.cfg.Webhook.MaxAttempts {
+				event.Status = data.EventDead
+				log.Printf("webhook %s %s to %s is dead: %s",
+					event.Type, event.ID, event.URL, err)
+			}
+		}
+
+		if err := s.inLease(func(q *reform.Querier) error {
+			return q.Save(event)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// webhookBackoff returns a delay in seconds before the retry
+// after the number of failed attempts.
+func (s *Scheduler) webhookBackoff(attempts uint64) uint64 {
+	delay := s.cfg.Webhook.MinBackoff
+	for i := uint64(1); i < attempts && delay < s.cfg.Webhook.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > s.cfg.Webhook.MaxBackoff {
+		delay = s.cfg.Webhook.MaxBackoff
+	}
+	return delay
+}
+
+// deliver posts a signed event to its endpoint.
+func (s *Scheduler) deliver(ctx context.Context,
+	event *data.WebhookEvent) error {
+	body := []byte(event.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		event.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventType, event.Type)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature,
+		Sign(s.cfg.Webhook.Secret, timestamp, body))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK ||
+		resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("unexpected response status: %s",
+			resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package proc_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/dzeckelev/geth-wrapper/config"
+	"github.com/dzeckelev/geth-wrapper/data"
+	"github.com/dzeckelev/geth-wrapper/eth"
+	"github.com/dzeckelev/geth-wrapper/gen"
+	"github.com/dzeckelev/geth-wrapper/proc"
+)
+
+func eventRow(event *data.WebhookEvent) (result []driver.Value) {
+	for _, v := range event.Values() {
+		result = append(result, v)
+	}
+	return result
+}
+
+func TestScheduler_DeliverEvents(t *testing.T) {
+	dataBase, sqlMock := newDB(t)
+	client, _ := newEthClient(t)
+
+	const secret = "secret"
+
+	var received []string
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+
+			timestamp, err := strconv.ParseInt(
+				r.Header.Get(proc.HeaderTimestamp), 10, 64)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if age := time.Since(time.Unix(timestamp, 0)); age < 0 ||
+				age > time.Minute {
+				t.Errorf("unexpected timestamp %d", timestamp)
+			}
+
+			if r.Header.Get(proc.HeaderSignature) !=
+				proc.Sign(secret, timestamp, body) {
+				t.Errorf("invalid signature of %s", body)
+			}
+
+			received = append(received, r.Header.Get(proc.HeaderEventType))
+			w.WriteHeader(status)
+		}
+	}
+
+	good := httptest.NewServer(handler(http.StatusOK))
+	defer good.Close()
+
+	bad := httptest.NewServer(handler(http.StatusInternalServerError))
+	defer bad.Close()
+
+	cfg := config.NewConfig()
+	cfg.Webhook.URLs = []string{good.URL, bad.URL}
+	cfg.Webhook.Secret = secret
+	cfg.Webhook.MaxAttempts = 2
+
+	scheduler, err := proc.NewScheduler(context.Background(),
+		eth.MockChainID, cfg, dataBase, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := uint64(time.Now().Unix())
+	newEvent := func(url string, attempts uint64) *data.WebhookEvent {
+		return &data.WebhookEvent{
+			ID:            gen.NewUUID(),
+			Type:          proc.EventDepositSeen,
+			Key:           proc.EventDepositSeen + ":" + gen.NewUUID(),
+			URL:           url,
+			Payload:       `{"type":"deposit.seen"}`,
+			Status:        data.EventPending,
+			Attempts:      attempts,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+	}
+
+	delivered := newEvent(good.URL, 0)
+	retried := newEvent(bad.URL, 0)
+	dead := newEvent(bad.URL, 1)
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "webhook_events"`).
+		WithArgs(data.EventPending, sqlmock.AnyArg(), 100).
+		WillReturnRows(sqlmock.NewRows(delivered.Table().Columns()).
+			AddRow(eventRow(delivered)...).
+			AddRow(eventRow(retried)...).
+			AddRow(eventRow(dead)...))
+
+	update := func(event *data.WebhookEvent, status string,
+		attempts uint64) {
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(`UPDATE "webhook_events"`).WithArgs(
+			event.Type, event.Key, event.URL, event.Payload, status,
+			attempts, sqlmock.AnyArg(), sqlmock.AnyArg(), now,
+			sqlmock.AnyArg(), event.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		sqlMock.ExpectCommit()
+	}
+
+	update(delivered, data.EventDelivered, 0)
+	update(retried, data.EventPending, 1)
+	update(dead, data.EventDead, 2)
+
+	if err := proc.DeliverEvents(scheduler, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(received))
+	}
+
+	if err := sqlMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSchedulerWebhookConfig(t *testing.T) {
+	dataBase, _ := newDB(t)
+	client, _ := newEthClient(t)
+
+	cfg := config.NewConfig()
+	cfg.Webhook.URLs = []string{"http://localhost/webhook"}
+
+	if _, err := proc.NewScheduler(context.Background(), eth.MockChainID,
+		cfg, dataBase, client); err == nil {
+		t.Fatal("expected error for webhooks without secret")
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"deposit.seen"}`)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1660000000."))
+	mac.Write(body)
+	exp := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	sig := proc.Sign("secret", 1660000000, body)
+	if sig != exp {
+		t.Fatalf("expected signature %s, got %s", exp, sig)
+	}
+
+	// The timestamp is signed, so a request cannot be replayed
+	// with a fresh one.
+	if sig == proc.Sign("secret", 1660000001, body) {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+	if sig == proc.Sign("other", 1660000000, body) {
+		t.Fatal("signature does not depend on the secret")
+	}
+}